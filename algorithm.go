@@ -0,0 +1,95 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Algorithm identifies the AEAD that encrypts the payload of an envelope or
+// stream. It is recorded in the authenticated header, so a reader needs no
+// configuration to open data sealed with any supported algorithm.
+type Algorithm byte
+
+const (
+	// AES256GCM is AES-256 in Galois/Counter Mode with a random 96-bit
+	// nonce. It is the default.
+	AES256GCM Algorithm = 1
+	// XChaCha20Poly1305 uses a random 192-bit nonce, so one key can seal
+	// practically any number of envelopes, and it is fast on processors
+	// without AES instructions.
+	XChaCha20Poly1305 Algorithm = 2
+	// AES256GCMSIV is AES-256-GCM-SIV from RFC 8452. It resists nonce
+	// misuse: if the random source repeats a nonce, for example after a
+	// virtual machine snapshot is restored, an attacker learns only whether
+	// two envelopes hold the same plaintext, and keys and other plaintexts
+	// stay protected. Sealing reads the plaintext twice, so it is slower than
+	// AES256GCM.
+	AES256GCMSIV Algorithm = 3
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AES256GCM:
+		return "AES-256-GCM"
+	case XChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	case AES256GCMSIV:
+		return "AES-256-GCM-SIV"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", byte(a))
+	}
+}
+
+func (a Algorithm) valid() bool {
+	return a == AES256GCM || a == XChaCha20Poly1305 || a == AES256GCMSIV
+}
+
+func (a Algorithm) nonceSize() int {
+	if a == XChaCha20Poly1305 {
+		return chacha20poly1305.NonceSizeX
+	}
+	return 12
+}
+
+// WithAlgorithm selects the AEAD for new envelopes and streams. Data sealed
+// with any supported algorithm can be opened regardless of this option.
+func WithAlgorithm(a Algorithm) Option {
+	return func(c *config) error {
+		if !a.valid() {
+			return fmt.Errorf("%w: unsupported algorithm %v", ErrInvalidEnvelope, a)
+		}
+		c.alg = a
+		return nil
+	}
+}
+
+// WithPasswordAlgorithm selects the AEAD for new password-based envelopes and
+// streams.
+func WithPasswordAlgorithm(a Algorithm) PasswordOption {
+	return func(c *passwordConfig) error {
+		return WithAlgorithm(a)(&c.config)
+	}
+}
+
+func newAEAD(a Algorithm, key []byte) (cipher.AEAD, error) {
+	switch a {
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	case AES256GCMSIV:
+		if len(key) != keySize {
+			return nil, aes.KeySizeError(len(key))
+		}
+		return newGCMSIV(key)
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %v", ErrInvalidEnvelope, a)
+	}
+}
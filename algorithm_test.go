@@ -0,0 +1,263 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAlgorithmEnvelopes(t *testing.T) {
+	for _, alg := range []Algorithm{XChaCha20Poly1305, AES256GCMSIV} {
+		t.Run(alg.String(), func(t *testing.T) { testAlgorithmEnvelopes(t, alg) })
+	}
+}
+
+func testAlgorithmEnvelopes(t *testing.T, alg Algorithm) {
+	x := WithAlgorithm(alg)
+	c, _ := NewCipher(testKey, x)
+	ided, _ := NewCipher(testKey, x, WithKeyID([]byte("k1")))
+	dataKeys, _ := NewCipher(testKey, x, WithDataKeys())
+	password, _ := NewPasswordCipher([]byte("correct horse"), WithPasswordAlgorithm(alg))
+	identity, _ := GenerateX25519Identity(x)
+	hybrid, _ := GenerateHybridIdentity(x)
+	multi, _ := NewMultiRecipient([]Recipient{c, identity.Recipient()}, x)
+
+	plain, _ := NewCipher(testKey)
+	plainIDed, _ := NewCipher(testKey, WithKeyID([]byte("k1")))
+	plainPassword, _ := NewPasswordCipher([]byte("correct horse"))
+	plainIdentity, _ := NewX25519Identity(identity.Bytes())
+	plainHybrid, _ := NewHybridIdentity(hybrid.Bytes())
+	for name, tc := range map[string]struct {
+		seal interface {
+			Seal(plaintext, additionalData []byte) (string, error)
+		}
+		open Codec
+	}{
+		"key":      {c, plain},
+		"key ID":   {ided, plainIDed},
+		"data key": {dataKeys, plain},
+		"password": {password, plainPassword},
+		"x25519":   {identity, plainIdentity},
+		"hybrid":   {hybrid, plainHybrid},
+		"multi":    {multi, plainIdentity},
+	} {
+		aad := []byte(name)
+		envelope, err := tc.seal.Seal([]byte("payload"), aad)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+		// The algorithm is always the first extension.
+		if packed[1]&flagExtensions == 0 || packed[4] != extAlgorithm || packed[5] != 0 || packed[6] != 1 || packed[7] != byte(alg) {
+			t.Fatalf("%s: header % x does not record the algorithm", name, packed[:8])
+		}
+		if got, err := tc.open.Open(envelope, aad); err != nil || string(got) != "payload" {
+			t.Fatalf("%s: Open() = %q, %v", name, got, err)
+		}
+		if _, err := tc.open.Open(envelope, nil); !errors.Is(err, ErrAuthentication) {
+			t.Fatalf("%s: wrong AAD error = %v", name, err)
+		}
+	}
+}
+
+func TestAlgorithmDefaultHeaderUnchanged(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithAlgorithm(AES256GCM)}} {
+		c, _ := NewCipher(testKey, opts...)
+		envelope, _ := c.EncryptString("x")
+		packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+		if packed[1] != modeKey || len(packed) != 2+12+1+16 {
+			t.Fatalf("default header = % x", packed[:2])
+		}
+	}
+}
+
+func TestAlgorithmStreams(t *testing.T) {
+	for _, alg := range []Algorithm{XChaCha20Poly1305, AES256GCMSIV} {
+		t.Run(alg.String(), func(t *testing.T) { testAlgorithmStreams(t, alg) })
+	}
+}
+
+func testAlgorithmStreams(t *testing.T, alg Algorithm) {
+	x := WithAlgorithm(alg)
+	c, _ := NewCipher(testKey, x)
+	dataKeys, _ := NewCipher(testKey, x, WithDataKeys())
+	password, _ := NewPasswordCipher([]byte("correct horse"), WithPasswordAlgorithm(alg))
+	identity, _ := GenerateX25519Identity(x)
+	hybrid, _ := GenerateHybridIdentity(x)
+	multi, _ := NewMultiRecipient([]Recipient{c}, x)
+
+	plain, _ := NewCipher(testKey)
+	plainPassword, _ := NewPasswordCipher([]byte("correct horse"))
+	input := bytes.Repeat([]byte("0123456789"), streamChunkSize/5)
+	for name, tc := range map[string]struct {
+		w func(io.Writer, ...StreamOption) (io.WriteCloser, error)
+		r func(io.Reader, ...StreamOption) (io.Reader, error)
+	}{
+		"key":      {c.NewEncryptWriter, plain.NewDecryptReader},
+		"data key": {dataKeys.NewEncryptWriter, plain.NewDecryptReader},
+		"password": {password.NewEncryptWriter, plainPassword.NewDecryptReader},
+		"x25519":   {identity.Recipient().NewEncryptWriter, identity.NewDecryptReader},
+		"hybrid":   {hybrid.Recipient().NewEncryptWriter, hybrid.NewDecryptReader},
+		"multi":    {multi.NewEncryptWriter, plain.NewDecryptReader},
+	} {
+		var encrypted bytes.Buffer
+		w, err := tc.w(&encrypted)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if b := encrypted.Bytes(); b[len(streamMagic)]&flagExtensions == 0 || b[len(streamMagic)+6] != byte(alg) {
+			t.Fatalf("%s: stream header does not record the algorithm", name)
+		}
+		r, err := tc.r(bytes.NewReader(encrypted.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+			t.Fatalf("%s: ReadAll() = %d bytes, %v", name, len(got), err)
+		}
+	}
+}
+
+func TestAlgorithmSurvivesRewrap(t *testing.T) {
+	from, _ := NewCipher(testKey, WithAlgorithm(XChaCha20Poly1305), WithDataKeys())
+	to, _ := NewCipher(bytes.Repeat([]byte{7}, keySize))
+	envelope, _ := from.EncryptString("rotated")
+	rewrapped, err := RewrapDataKey(envelope, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := to.DecryptString(rewrapped); err != nil || got != "rotated" {
+		t.Fatalf("DecryptString() = %q, %v", got, err)
+	}
+
+	var encrypted, moved bytes.Buffer
+	w, _ := from.NewEncryptWriter(&encrypted)
+	io.WriteString(w, "rotated stream")
+	w.Close()
+	if err := RewrapStreamDataKey(&moved, &encrypted, from, to); err != nil {
+		t.Fatal(err)
+	}
+	r, err := to.NewDecryptReader(&moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "rotated stream" {
+		t.Fatalf("ReadAll() = %q, %v", got, err)
+	}
+}
+
+func TestAlgorithmRejectsHostileHeaders(t *testing.T) {
+	c, _ := NewCipher(testKey, WithAlgorithm(XChaCha20Poly1305))
+	envelope, _ := c.EncryptString("x")
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	encode := func(b []byte) string { return prefix + base64.RawURLEncoding.EncodeToString(b) }
+	mutate := func(f func(b []byte) []byte) string {
+		return encode(f(append([]byte(nil), packed...)))
+	}
+	for name, tc := range map[string]struct {
+		envelope string
+		want     error
+	}{
+		"downgrade":         {mutate(func(b []byte) []byte { b[7] = byte(AES256GCM); return b }), ErrAuthentication},
+		"unknown algorithm": {mutate(func(b []byte) []byte { b[7] = 9; return b }), ErrInvalidEnvelope},
+		"unknown extension": {mutate(func(b []byte) []byte { b[4] = 0x7f; return b }), ErrInvalidEnvelope},
+		"bad value length":  {mutate(func(b []byte) []byte { b[6] = 2; return b }), ErrInvalidEnvelope},
+		"block overrun":     {mutate(func(b []byte) []byte { b[3] = 0xff; return b[:40] }), ErrInvalidEnvelope},
+		"empty block": {mutate(func(b []byte) []byte {
+			return append([]byte{b[0], b[1], 0, 0}, b[8:]...)
+		}), ErrInvalidEnvelope},
+		"duplicate": {mutate(func(b []byte) []byte {
+			return append([]byte{b[0], b[1], 0, 8, 1, 0, 1, 2, 1, 0, 1, 2}, b[8:]...)
+		}), ErrInvalidEnvelope},
+		"flag removed": {mutate(func(b []byte) []byte { b[1] &^= flagExtensions; return b }), ErrAuthentication},
+	} {
+		if _, err := c.DecryptString(tc.envelope); !errors.Is(err, tc.want) {
+			t.Fatalf("%s error = %v, want %v", name, err, tc.want)
+		}
+	}
+
+	var encrypted bytes.Buffer
+	w, _ := c.NewEncryptWriter(&encrypted)
+	w.Close()
+	stream := encrypted.Bytes()
+	for name, tc := range map[string]struct {
+		stream []byte
+		want   error
+	}{
+		"truncated length":  {stream[:len(streamMagic)+2], ErrTruncated},
+		"truncated block":   {stream[:len(streamMagic)+5], ErrTruncated},
+		"empty block":       {append([]byte(streamMagic), modeKey|flagExtensions, 0, 0), ErrInvalidEnvelope},
+		"unknown algorithm": {append(append([]byte(nil), stream[:len(streamMagic)+6]...), 9), ErrInvalidEnvelope},
+	} {
+		if _, err := c.NewDecryptReader(bytes.NewReader(tc.stream)); !errors.Is(err, tc.want) {
+			t.Fatalf("%s error = %v, want %v", name, err, tc.want)
+		}
+	}
+	downgraded := append([]byte(nil), stream...)
+	downgraded[len(streamMagic)+6] = byte(AES256GCM)
+	r, err := c.NewDecryptReader(bytes.NewReader(downgraded))
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("downgraded stream error = %v", err)
+	}
+}
+
+func TestAlgorithmOptions(t *testing.T) {
+	for _, a := range []Algorithm{0, 4, 0xff} {
+		if _, err := NewCipher(testKey, WithAlgorithm(a)); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("WithAlgorithm(%d) error = %v", a, err)
+		}
+		if _, err := NewPasswordCipher([]byte("pw"), WithPasswordAlgorithm(a)); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("WithPasswordAlgorithm(%d) error = %v", a, err)
+		}
+		if _, err := newAEAD(a, testKey); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("newAEAD(%d) error = %v", a, err)
+		}
+	}
+	for a, want := range map[Algorithm]string{AES256GCM: "AES-256-GCM", XChaCha20Poly1305: "XChaCha20-Poly1305", AES256GCMSIV: "AES-256-GCM-SIV", 9: "Algorithm(9)"} {
+		if a.String() != want {
+			t.Fatalf("String() = %q, want %q", a.String(), want)
+		}
+	}
+	c, _ := NewCipher(testKey, WithAlgorithm(XChaCha20Poly1305), WithMaxEnvelopeSize(64))
+	const maxPlaintext = 64 - 8 - 24 - 16
+	if _, err := c.Seal(make([]byte, maxPlaintext+1), nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("oversized seal error = %v", err)
+	}
+	if _, err := c.Seal(make([]byte, maxPlaintext), nil); err != nil {
+		t.Fatalf("seal at limit error = %v", err)
+	}
+}
+
+func TestAlgorithmNonceReuse(t *testing.T) {
+	c, _ := NewCipher(testKey, WithAlgorithm(AES256GCMSIV))
+	c.cfg.rand = bytes.NewReader(make([]byte, 1024)) // every nonce is zero
+	first, _ := c.EncryptString("attack at dawn")
+	second, _ := c.EncryptString("attack at dawn")
+	third, _ := c.EncryptString("attack at dusk")
+	if first != second {
+		t.Fatal("equal plaintexts produced different envelopes under one nonce")
+	}
+	a, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(first, prefix))
+	b, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(third, prefix))
+	ciphertextStart := 8 + 12
+	if bytes.Equal(a[ciphertextStart:ciphertextStart+12], b[ciphertextStart:ciphertextStart+12]) {
+		t.Fatal("a repeated nonce reused the keystream")
+	}
+	for _, envelope := range []string{first, third} {
+		if _, err := c.DecryptString(envelope); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	defaultIndexSize = 16
+	maxIndexNameSize = 255
+	// maxIndexedRunes bounds the number of tokens a prefix or n-gram index
+	// produces for one value.
+	maxIndexedRunes = 1024
+)
+
+const (
+	indexFull = iota + 1
+	indexPrefix
+	indexNGram
+)
+
+type blindIndexConfig struct {
+	size      int
+	fold      bool
+	kind      byte
+	prefixMin int
+	ngram     int
+}
+
+// BlindIndexOption configures a BlindIndexer.
+type BlindIndexOption func(*blindIndexConfig) error
+
+// WithIndexSize sets the token length in bytes, between 1 and 32. Shorter
+// tokens make more values share a token, which leaks less about which rows
+// are equal but returns more false positives that must be filtered after
+// decryption. The default is 16.
+func WithIndexSize(n int) BlindIndexOption {
+	return func(c *blindIndexConfig) error {
+		if n < 1 || n > sha256.Size {
+			return fmt.Errorf("%w: index size must be between 1 and %d bytes", ErrLimitExceeded, sha256.Size)
+		}
+		c.size = n
+		return nil
+	}
+}
+
+// WithCaseNormalization lower-cases values and queries before indexing, so
+// that lookups ignore case.
+func WithCaseNormalization() BlindIndexOption {
+	return func(c *blindIndexConfig) error {
+		c.fold = true
+		return nil
+	}
+}
+
+// WithPrefixIndex indexes every prefix of a value that is at least minLen
+// characters long, so that queries match values starting with the term.
+func WithPrefixIndex(minLen int) BlindIndexOption {
+	return func(c *blindIndexConfig) error {
+		if minLen < 1 || minLen > maxIndexedRunes {
+			return fmt.Errorf("%w: prefix length must be between 1 and %d", ErrLimitExceeded, maxIndexedRunes)
+		}
+		c.kind, c.prefixMin, c.ngram = indexPrefix, minLen, 0
+		return nil
+	}
+}
+
+// WithNGramIndex indexes every distinct substring of n characters, so that
+// queries match values containing the term.
+func WithNGramIndex(n int) BlindIndexOption {
+	return func(c *blindIndexConfig) error {
+		if n < 1 || n > maxIndexedRunes {
+			return fmt.Errorf("%w: n-gram length must be between 1 and %d", ErrLimitExceeded, maxIndexedRunes)
+		}
+		c.kind, c.ngram, c.prefixMin = indexNGram, n, 0
+		return nil
+	}
+}
+
+// BlindIndexer computes blind indexes: truncated HMAC-SHA256 tokens that are
+// stored next to randomized envelopes so that encrypted columns can be
+// searched without deterministic encryption. By default a value has a single
+// token; WithPrefixIndex and WithNGramIndex produce one token per prefix or
+// n-gram instead.
+//
+// Tokens reveal which rows share a value, prefix, or n-gram to anyone who can
+// read them, and the number of tokens reveals the length of the value. Use a
+// separate name for every indexed field.
+type BlindIndexer struct {
+	key [keySize]byte
+	cfg blindIndexConfig
+}
+
+// NewBlindIndexer creates an indexer for the field identified by name. Its
+// HMAC key is derived from the cipher's key and name, so indexers for
+// different fields produce unrelated tokens.
+func NewBlindIndexer(c *Cipher, name string, opts ...BlindIndexOption) (*BlindIndexer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if len(name) == 0 || len(name) > maxIndexNameSize {
+		return nil, fmt.Errorf("%w: index name must be between 1 and %d bytes", ErrLimitExceeded, maxIndexNameSize)
+	}
+	cfg := blindIndexConfig{size: defaultIndexSize, kind: indexFull}
+	for _, opt := range opts {
+		if opt == nil {
+			return nil, errors.New("secure: nil blind index option")
+		}
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	b := &BlindIndexer{cfg: cfg}
+	r := hkdf.New(sha256.New, c.key[:], nil, []byte("github.com/rusq/secure/v2 blind index "+name))
+	if _, err := io.ReadFull(r, b.key[:]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *BlindIndexer) validate() error {
+	if b == nil || b.cfg.size == 0 {
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// Index returns the tokens to store for value, sorted and without duplicates.
+// A value shorter than the n-gram length has no tokens.
+func (b *BlindIndexer) Index(value string) ([][]byte, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	runes, err := b.normalize(value)
+	if err != nil {
+		return nil, err
+	}
+	var tokens [][]byte
+	switch b.cfg.kind {
+	case indexPrefix:
+		for n := b.cfg.prefixMin; n <= len(runes); n++ {
+			tokens = append(tokens, b.token(runes[:n]))
+		}
+	case indexNGram:
+		for i := 0; i+b.cfg.ngram <= len(runes); i++ {
+			tokens = append(tokens, b.token(runes[i:i+b.cfg.ngram]))
+		}
+	default:
+		tokens = append(tokens, b.token(runes))
+	}
+	slices.SortFunc(tokens, bytes.Compare)
+	return slices.CompactFunc(tokens, bytes.Equal), nil
+}
+
+// Query returns the tokens that a stored value must all contain to match
+// term: the token of the whole term for full-value and prefix indexes, and
+// the tokens of its n-grams for n-gram indexes.
+func (b *BlindIndexer) Query(term string) ([][]byte, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	runes, err := b.normalize(term)
+	if err != nil {
+		return nil, err
+	}
+	switch b.cfg.kind {
+	case indexPrefix:
+		if len(runes) < b.cfg.prefixMin {
+			return nil, fmt.Errorf("secure: query must be at least %d characters", b.cfg.prefixMin)
+		}
+	case indexNGram:
+		if len(runes) < b.cfg.ngram {
+			return nil, fmt.Errorf("secure: query must be at least %d characters", b.cfg.ngram)
+		}
+		return b.Index(term)
+	}
+	return [][]byte{b.token(runes)}, nil
+}
+
+func (b *BlindIndexer) normalize(value string) ([]rune, error) {
+	if b.cfg.fold {
+		value = strings.ToLower(value)
+	}
+	if b.cfg.kind != indexFull && utf8.RuneCountInString(value) > maxIndexedRunes {
+		return nil, fmt.Errorf("%w: indexed values are limited to %d characters", ErrLimitExceeded, maxIndexedRunes)
+	}
+	return []rune(value), nil
+}
+
+// token computes HMAC(kind || value), truncated to the configured size. The
+// kind keeps a full-value token apart from an equal prefix or n-gram.
+func (b *BlindIndexer) token(value []rune) []byte {
+	m := hmac.New(sha256.New, b.key[:])
+	m.Write([]byte{b.cfg.kind})
+	m.Write([]byte(string(value)))
+	return m.Sum(nil)[:b.cfg.size]
+}
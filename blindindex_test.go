@@ -0,0 +1,166 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBlindIndexFullValue(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	b, err := NewBlindIndexer(c, "users.email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := b.Index("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HMAC-SHA256(HKDF(key, "… blind index users.email"), 0x01 || value)[:16]
+	if len(tokens) != 1 || hex.EncodeToString(tokens[0]) != "b75bbd3c7b45305c12c0e5f12b4fc750" {
+		t.Fatalf("Index() = %x", tokens)
+	}
+	query, err := b.Query("alice@example.com")
+	if err != nil || len(query) != 1 || !bytes.Equal(query[0], tokens[0]) {
+		t.Fatalf("Query() = %x, %v", query, err)
+	}
+	if other, _ := b.Query("Alice@example.com"); bytes.Equal(other[0], tokens[0]) {
+		t.Fatal("index is case-insensitive without normalization")
+	}
+
+	otherField, _ := NewBlindIndexer(c, "users.backup_email")
+	otherKey, _ := NewCipher(bytes.Repeat([]byte{0x24}, keySize))
+	otherCipher, _ := NewBlindIndexer(otherKey, "users.email")
+	for name, ix := range map[string]*BlindIndexer{"name": otherField, "key": otherCipher} {
+		if got, _ := ix.Index("alice@example.com"); bytes.Equal(got[0], tokens[0]) {
+			t.Fatalf("different %s produced the same token", name)
+		}
+	}
+
+	folded, _ := NewBlindIndexer(c, "users.email", WithCaseNormalization(), WithIndexSize(4))
+	lower, _ := folded.Index("alice@example.com")
+	upper, _ := folded.Query("ALICE@Example.COM")
+	if len(lower[0]) != 4 || !bytes.Equal(lower[0], upper[0]) {
+		t.Fatalf("normalized tokens %x, %x", lower, upper)
+	}
+	if !bytes.Equal(lower[0], tokens[0][:4]) {
+		t.Fatal("normalization changed the token of a lower-case value")
+	}
+}
+
+func TestBlindIndexPrefix(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	b, _ := NewBlindIndexer(c, "users.name", WithPrefixIndex(3), WithCaseNormalization())
+	tokens, err := b.Index("Ålesund")
+	if err != nil || len(tokens) != 5 {
+		t.Fatalf("Index() = %d tokens, %v", len(tokens), err)
+	}
+	for _, term := range []string{"åle", "ÅLESUND"} {
+		query, err := b.Query(term)
+		if err != nil || len(query) != 1 || !containsToken(tokens, query[0]) {
+			t.Fatalf("Query(%q) did not match", term)
+		}
+	}
+	if query, _ := b.Query("les"); containsToken(tokens, query[0]) {
+		t.Fatal("non-prefix matched")
+	}
+	if _, err := b.Query("ål"); err == nil {
+		t.Fatal("accepted a query shorter than the minimum prefix")
+	}
+	if short, err := b.Index("ab"); err != nil || len(short) != 0 {
+		t.Fatalf("Index(short) = %x, %v", short, err)
+	}
+
+	full, _ := NewBlindIndexer(c, "users.name", WithCaseNormalization())
+	whole, _ := full.Index("ålesund")
+	if containsToken(tokens, whole[0]) {
+		t.Fatal("full-value and prefix tokens collide")
+	}
+}
+
+func TestBlindIndexNGram(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	b, _ := NewBlindIndexer(c, "notes.body", WithNGramIndex(3))
+	tokens, err := b.Index("banana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ban, ana, nan, ana: the repeated trigram is stored once.
+	if len(tokens) != 3 {
+		t.Fatalf("Index() = %d tokens", len(tokens))
+	}
+	for i := 1; i < len(tokens); i++ {
+		if bytes.Compare(tokens[i-1], tokens[i]) >= 0 {
+			t.Fatal("tokens are not sorted")
+		}
+	}
+	query, err := b.Query("nana")
+	if err != nil || len(query) != 2 {
+		t.Fatalf("Query() = %x, %v", query, err)
+	}
+	for _, token := range query {
+		if !containsToken(tokens, token) {
+			t.Fatal("substring did not match")
+		}
+	}
+	if query, _ := b.Query("nab"); containsToken(tokens, query[0]) {
+		t.Fatal("absent trigram matched")
+	}
+	if _, err := b.Query("an"); err == nil {
+		t.Fatal("accepted a query shorter than n")
+	}
+}
+
+func TestBlindIndexConfiguration(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	if _, err := NewBlindIndexer(nil, "x"); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil cipher error = %v", err)
+	}
+	for name, tc := range map[string]struct {
+		name string
+		opt  BlindIndexOption
+	}{
+		"empty name":  {"", WithIndexSize(16)},
+		"long name":   {strings.Repeat("x", maxIndexNameSize+1), WithIndexSize(16)},
+		"nil option":  {"x", nil},
+		"zero size":   {"x", WithIndexSize(0)},
+		"large size":  {"x", WithIndexSize(33)},
+		"zero prefix": {"x", WithPrefixIndex(0)},
+		"large n":     {"x", WithNGramIndex(maxIndexedRunes + 1)},
+	} {
+		if _, err := NewBlindIndexer(c, tc.name, tc.opt); err == nil {
+			t.Fatalf("%s was accepted", name)
+		}
+	}
+	var unconfigured *BlindIndexer
+	if _, err := unconfigured.Index("x"); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil Index error = %v", err)
+	}
+	if _, err := (&BlindIndexer{}).Query("x"); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("zero Query error = %v", err)
+	}
+
+	long := strings.Repeat("é", maxIndexedRunes+1)
+	ngram, _ := NewBlindIndexer(c, "x", WithNGramIndex(2))
+	if _, err := ngram.Index(long); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("long n-gram value error = %v", err)
+	}
+	if _, err := ngram.Query(long); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("long n-gram query error = %v", err)
+	}
+	full, _ := NewBlindIndexer(c, "x")
+	if _, err := full.Index(long); err != nil {
+		t.Fatalf("full-value index of a long value: %v", err)
+	}
+}
+
+func containsToken(tokens [][]byte, token []byte) bool {
+	for _, t := range tokens {
+		if bytes.Equal(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package secure
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// CalibrateArgon2 benchmarks Argon2id on the current machine and returns the
+// strongest parameters within the limits accepted by WithArgon2Parameters
+// that derive a key in at most target. maxMemory, in KiB, caps the memory
+// cost and must be at least the 64 MiB minimum. Memory is raised before the
+// number of passes, and Threads is the number of CPUs, clamped to the
+// accepted range.
+//
+// Calibration runs Argon2id several times, so it takes a few multiples of
+// target and should be run once per machine type, not on every start. The
+// result reflects the load at the time of the run; leave some headroom for
+// latency objectives. ErrLimitExceeded is returned if even the minimum cost
+// exceeds target.
+func CalibrateArgon2(target time.Duration, maxMemory uint32) (Argon2Parameters, error) {
+	threads := uint8(min(max(runtime.NumCPU(), int(defaultArgonThreads)), int(maxArgonThreads)))
+	return calibrateArgon2(target, maxMemory, threads, measureArgon2)
+}
+
+func measureArgon2(p Argon2Parameters) time.Duration {
+	salt := make([]byte, saltSize)
+	start := time.Now()
+	clear(argon2.IDKey([]byte("github.com/rusq/secure/v2 calibration"), salt, p.Time, p.Memory, p.Threads, keySize))
+	return time.Since(start)
+}
+
+func calibrateArgon2(target time.Duration, maxMemory uint32, threads uint8, measure func(Argon2Parameters) time.Duration) (Argon2Parameters, error) {
+	if target <= 0 {
+		return Argon2Parameters{}, fmt.Errorf("%w: calibration target must be positive", ErrLimitExceeded)
+	}
+	if maxMemory < defaultArgonMemory {
+		return Argon2Parameters{}, fmt.Errorf("%w: maximum memory must be at least %d KiB", ErrLimitExceeded, defaultArgonMemory)
+	}
+	p := Argon2Parameters{Time: defaultArgonTime, Memory: min(maxMemory, maxArgonMemory), Threads: threads}
+	elapsed := measure(p)
+	for elapsed > target {
+		if p.Memory == defaultArgonMemory {
+			return Argon2Parameters{}, fmt.Errorf("%w: the minimum Argon2id cost takes %v, more than %v", ErrLimitExceeded, elapsed, target)
+		}
+		// The cost grows linearly with memory. Round down to whole MiB.
+		next := uint32(float64(p.Memory)*float64(target)/float64(elapsed)) &^ 1023
+		p.Memory = max(next, defaultArgonMemory)
+		elapsed = measure(p)
+	}
+	// Spend the rest of the budget on passes, which also cost linearly.
+	fits := p
+	p.Time = uint32(min(uint64(target)*uint64(p.Time)/uint64(max(elapsed, 1)), uint64(maxArgonTime)))
+	for p.Time > fits.Time {
+		if measure(p) <= target {
+			return p, nil
+		}
+		p.Time--
+	}
+	return fits, nil
+}
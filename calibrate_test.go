@@ -0,0 +1,49 @@
+package secure
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCalibrateArgon2(t *testing.T) {
+	// The model costs 300ms for the minimum parameters and is linear in
+	// passes and memory.
+	var runs int
+	model := func(p Argon2Parameters) time.Duration {
+		runs++
+		return 100 * time.Millisecond * time.Duration(p.Time) * time.Duration(p.Memory) / time.Duration(defaultArgonMemory)
+	}
+	for _, tt := range []struct {
+		target    time.Duration
+		maxMemory uint32
+		want      Argon2Parameters
+	}{
+		{time.Second, 1 << 30, Argon2Parameters{Time: 3, Memory: 213 << 10, Threads: 8}},
+		{2 * time.Second, maxArgonMemory, Argon2Parameters{Time: 5, Memory: maxArgonMemory, Threads: 8}},
+		{10 * time.Second, defaultArgonMemory, Argon2Parameters{Time: maxArgonTime, Memory: defaultArgonMemory, Threads: 8}},
+		{300 * time.Millisecond, maxArgonMemory, Argon2Parameters{Time: 3, Memory: defaultArgonMemory, Threads: 8}},
+	} {
+		runs = 0
+		got, err := calibrateArgon2(tt.target, tt.maxMemory, 8, model)
+		if err != nil || got != tt.want {
+			t.Fatalf("calibrateArgon2(%v, %d) = %+v, %v, want %+v", tt.target, tt.maxMemory, got, err, tt.want)
+		}
+		if err := validateArgon2(got); err != nil || model(got) > tt.target || runs > 5 {
+			t.Fatalf("calibrateArgon2(%v) = %+v after %d runs: %v", tt.target, got, runs, err)
+		}
+	}
+
+	if _, err := calibrateArgon2(299*time.Millisecond, maxArgonMemory, 4, model); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("unreachable target error = %v", err)
+	}
+	if _, err := CalibrateArgon2(0, maxArgonMemory); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("zero target error = %v", err)
+	}
+	if _, err := CalibrateArgon2(time.Second, defaultArgonMemory-1); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("small memory error = %v", err)
+	}
+	if _, err := CalibrateArgon2(time.Nanosecond, defaultArgonMemory); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("CalibrateArgon2(1ns) error = %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const commitmentSize = 32
+
+// WithKeyCommitment records a key commitment in every envelope, so that an
+// envelope can be opened only with the key it was sealed with. AES-GCM and
+// ChaCha20-Poly1305 alone allow a ciphertext to be crafted that opens under
+// many keys. Envelopes grow by at most 37 bytes; streams are not affected.
+func WithKeyCommitment() Option {
+	return func(c *config) error {
+		c.commit = true
+		return nil
+	}
+}
+
+// WithoutPasswordKeyCommitment omits the key commitment that password
+// envelopes carry by default. Use it only while readers that predate key
+// commitment must open new envelopes. Omitting the commitment weakens only
+// the envelopes it writes; see WithRequiredPasswordKeyCommitment for what
+// the commitment protects against.
+func WithoutPasswordKeyCommitment() PasswordOption {
+	return func(c *passwordConfig) error {
+		c.commit = false
+		return nil
+	}
+}
+
+// WithRequiredPasswordKeyCommitment makes Open reject envelopes without a key
+// commitment with ErrAuthentication. By default they are accepted, so that
+// envelopes sealed before key commitment stay readable, and an attacker who
+// can submit envelopes and observe which ones open can leave the commitment
+// out and test many passwords at once. The commitment protects against that
+// only once this option is enabled, which should follow resealing every
+// stored envelope, for example with OpenAndUpgrade. Envelopes for multiple
+// recipients must then be sealed with WithKeyCommitment. Streams are not
+// affected.
+func WithRequiredPasswordKeyCommitment() PasswordOption {
+	return func(c *passwordConfig) error {
+		c.requireCommit = true
+		return nil
+	}
+}
+
+// checkCommitted rejects envelopes without a key commitment if p requires
+// one.
+func (p *PasswordCipher) checkCommitted(env envelope) error {
+	if p.cfg.requireCommit && !env.ext.committed {
+		return ErrAuthentication
+	}
+	return nil
+}
+
+// keyCommitment derives a tag that identifies key. A committed envelope
+// records it, so that a ciphertext crafted to decrypt under many keys, as in
+// a partitioning-oracle attack, is rejected for every key but one before the
+// AEAD runs.
+func keyCommitment(key []byte) [commitmentSize]byte {
+	var tag [commitmentSize]byte
+	r := hkdf.New(sha256.New, key, nil, []byte("github.com/rusq/secure/v2 key commitment"))
+	_, _ = io.ReadFull(r, tag[:]) // cannot fail for 32 bytes
+	return tag
+}
+
+// checkCommitment verifies that key matches the commitment in x, if any.
+func checkCommitment(key []byte, x extensions) error {
+	if !x.committed {
+		return nil
+	}
+	tag := keyCommitment(key)
+	if subtle.ConstantTimeCompare(tag[:], x.commitment[:]) != 1 {
+		return ErrAuthentication
+	}
+	return nil
+}
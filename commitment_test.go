@@ -0,0 +1,176 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// legacyPasswordEnvelope was sealed by the previous release, without a
+// commitment, with the password "correct horse battery staple" and the
+// additional data "v2.0".
+const legacyPasswordEnvelope = "SEC2.AgIAAAADAAEAAAQHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHijyGNCFXxr86slAYxkKWYBDhR-jBj8fmfEy5nL71asUZ2fTixYWmbjwkpKM"
+
+func TestPasswordKeyCommitmentDefault(t *testing.T) {
+	p, _ := NewPasswordCipher([]byte("correct horse battery staple"))
+	envelope, err := p.Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	if packed[1] != modePassword|flagExtensions || packed[4] != extCommitment || packed[2] != 0 || packed[3] != 3+commitmentSize {
+		t.Fatalf("header % x does not carry a commitment", packed[:8])
+	}
+	if got, err := p.Open(envelope, nil); err != nil || string(got) != "secret" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	wrong, _ := NewPasswordCipher([]byte("wrong password"))
+	if _, err := wrong.Open(envelope, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong password error = %v", err)
+	}
+
+	if got, err := p.Open(legacyPasswordEnvelope, []byte("v2.0")); err != nil || string(got) != "sealed before key commitment" {
+		t.Fatalf("Open(legacy) = %q, %v", got, err)
+	}
+
+	uncommitted, _ := NewPasswordCipher([]byte("correct horse battery staple"), WithoutPasswordKeyCommitment())
+	envelope, _ = uncommitted.Seal([]byte("secret"), nil)
+	packed, _ = base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	if packed[1] != modePassword || len(packed) != 2+passwordParamsSize+12+len("secret")+tagSize {
+		t.Fatalf("WithoutPasswordKeyCommitment header % x", packed[:4])
+	}
+	if got, err := p.Open(envelope, nil); err != nil || string(got) != "secret" {
+		t.Fatalf("Open(uncommitted) = %q, %v", got, err)
+	}
+}
+
+func TestRequiredPasswordKeyCommitment(t *testing.T) {
+	password := []byte("correct horse battery staple")
+	p, _ := NewPasswordCipher(password, WithRequiredPasswordKeyCommitment())
+	uncommitted, _ := NewPasswordCipher(password, WithoutPasswordKeyCommitment())
+	crafted, _ := uncommitted.Seal([]byte("secret"), nil)
+	multi, _ := NewMultiRecipient([]Recipient{uncommitted})
+	craftedMulti, _ := multi.Seal([]byte("secret"), nil)
+	for name, envelope := range map[string]string{
+		"legacy":   legacyPasswordEnvelope,
+		"password": crafted,
+		"multi":    craftedMulti,
+	} {
+		if _, err := p.Open(envelope, []byte("v2.0")); !errors.Is(err, ErrAuthentication) {
+			t.Fatalf("%s: Open() error = %v", name, err)
+		}
+	}
+	if got, err := uncommitted.Open(crafted, nil); err != nil || string(got) != "secret" {
+		t.Fatalf("Open() without the option = %q, %v", got, err)
+	}
+
+	session, err := p.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if _, err := session.Open(crafted, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("session Open() error = %v", err)
+	}
+	envelope, err := session.Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := session.Open(envelope, nil); err != nil || string(got) != "secret" {
+		t.Fatalf("session Open() = %q, %v", got, err)
+	}
+	envelope, _ = p.Seal([]byte("secret"), nil)
+	if got, err := p.Open(envelope, nil); err != nil || string(got) != "secret" {
+		t.Fatalf("Open(committed) = %q, %v", got, err)
+	}
+}
+
+func TestKeyCommitmentCheckedBeforeAEAD(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	// The payload authenticates under testKey, but the header commits to a
+	// different key.
+	x := defaultExtensions()
+	x.committed, x.commitment = true, keyCommitment(bytes.Repeat([]byte{1}, keySize))
+	header := envelopePrefix(modeKey, x)
+	envelope, err := sealEnvelope(testKey, header, len(header), []byte("payload"), nil, c.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Open(envelope, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("mismatched commitment error = %v", err)
+	}
+
+	x.commitment = keyCommitment(testKey)
+	header = envelopePrefix(modeKey, x)
+	envelope, _ = sealEnvelope(testKey, header, len(header), []byte("payload"), nil, c.cfg)
+	if got, err := c.Open(envelope, nil); err != nil || string(got) != "payload" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	short := append([]byte{packed[0], packed[1], 0, 3 + commitmentSize - 1, extCommitment, 0, commitmentSize - 1}, packed[2+5+1:]...)
+	if _, err := c.Open(prefix+base64.RawURLEncoding.EncodeToString(short), nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("short commitment error = %v", err)
+	}
+}
+
+func TestWithKeyCommitment(t *testing.T) {
+	c, _ := NewCipher(testKey, WithKeyCommitment())
+	dataKeys, _ := NewCipher(testKey, WithKeyCommitment(), WithDataKeys())
+	identity, _ := GenerateX25519Identity(WithKeyCommitment())
+	multi, _ := NewMultiRecipient([]Recipient{c, identity.Recipient()}, WithKeyCommitment())
+	plain, _ := NewCipher(testKey)
+	for name, tc := range map[string]struct {
+		seal interface {
+			Seal(plaintext, additionalData []byte) (string, error)
+		}
+		open Codec
+	}{
+		"key":      {c, plain},
+		"data key": {dataKeys, plain},
+		"x25519":   {identity, identity},
+		"multi":    {multi, plain},
+	} {
+		envelope, err := tc.seal.Seal([]byte("payload"), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+		if packed[1]&flagExtensions == 0 || packed[4] != extCommitment {
+			t.Fatalf("%s: header % x does not carry a commitment", name, packed[:8])
+		}
+		if got, err := tc.open.Open(envelope, nil); err != nil || string(got) != "payload" {
+			t.Fatalf("%s: Open() = %q, %v", name, got, err)
+		}
+	}
+
+	// Rewrapping keeps the data key, so its commitment stays valid.
+	envelope, _ := dataKeys.Seal([]byte("payload"), nil)
+	next, _ := NewCipher(bytes.Repeat([]byte{0x24}, keySize))
+	rotated, err := RewrapDataKey(envelope, dataKeys, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := next.Open(rotated, nil); err != nil || string(got) != "payload" {
+		t.Fatalf("Open(rewrapped) = %q, %v", got, err)
+	}
+}
+
+func TestKeyCommitmentStreams(t *testing.T) {
+	p, _ := NewPasswordCipher([]byte("correct horse battery staple"))
+	var buf bytes.Buffer
+	w, _ := p.NewEncryptWriter(&buf)
+	w.Write([]byte("stream"))
+	w.Close()
+	if buf.Bytes()[len(streamMagic)] != modePassword {
+		t.Fatal("password stream carries extensions")
+	}
+
+	header := streamPrefix(modeKey, extensions{alg: AES256GCM, committed: true})
+	c, _ := NewCipher(testKey)
+	if _, err := c.NewDecryptReader(bytes.NewReader(append(header, make([]byte, saltSize)...))); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("committed stream header error = %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const maxWrappedKeySize = 1024
+
+// WithDataKeys makes a Cipher encrypt every envelope and stream with a fresh
+// random data key and store that key in the header, wrapped by the Cipher's
+// key. Rotating the wrapping key then only requires RewrapDataKey or
+// RewrapStreamDataKey, which rewrite the small wrapped key and leave the
+// payload untouched. A Cipher opens data key envelopes with or without this
+// option.
+func WithDataKeys() Option {
+	return func(c *config) error {
+		c.dataKeys = true
+		return nil
+	}
+}
+
+func (c *Cipher) wrapDataKey(dataKey []byte) ([]byte, error) {
+	aead, err := c.wrappingAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := io.ReadFull(c.cfg.rand, nonce); err != nil {
+		return nil, fmt.Errorf("secure: generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, dataKeyAAD(c.keyID)), nil
+}
+
+func (c *Cipher) unwrapDataKey(wrapped []byte) ([]byte, error) {
+	aead, err := c.wrappingAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidEnvelope
+	}
+	nonce := wrapped[:aead.NonceSize()]
+	dataKey, err := aead.Open(nil, nonce, wrapped[aead.NonceSize():], dataKeyAAD(c.keyID))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return dataKey, nil
+}
+
+func (c *Cipher) wrappingAEAD() (cipher.AEAD, error) {
+	r := hkdf.New(sha256.New, c.key[:], nil, []byte("github.com/rusq/secure/v2 key wrap"))
+	key := make([]byte, keySize)
+	defer clear(key)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return newAEAD(AES256GCM, key)
+}
+
+func dataKeyAAD(id []byte) []byte {
+	return append([]byte("github.com/rusq/secure/v2 data key"), id...)
+}
+
+func newDataKey(w KeyWrapper, rand io.Reader) (dataKey, header []byte, err error) {
+	dataKey = make([]byte, keySize)
+	if _, err := io.ReadFull(rand, dataKey); err != nil {
+		return nil, nil, fmt.Errorf("secure: generate data key: %w", err)
+	}
+	wrapped, err := w.WrapKey(dataKey)
+	if err != nil {
+		clear(dataKey)
+		return nil, nil, err
+	}
+	if len(wrapped) == 0 || len(wrapped) > maxWrappedKeySize {
+		clear(dataKey)
+		return nil, nil, fmt.Errorf("%w: wrapped key must be between 1 and %d bytes", ErrLimitExceeded, maxWrappedKeySize)
+	}
+	return dataKey, dataKeyHeader(w.KeyID(), wrapped), nil
+}
+
+// unwrapHeaderKey recovers the data key named by a header after checking that
+// w is the wrapper it was sealed with.
+func unwrapHeaderKey(w KeyWrapper, id, wrapped []byte) ([]byte, error) {
+	if !bytes.Equal(id, w.KeyID()) {
+		return nil, ErrKeyMismatch
+	}
+	dataKey, err := w.UnwrapKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(dataKey) != keySize {
+		clear(dataKey)
+		return nil, ErrAuthentication
+	}
+	return dataKey, nil
+}
+
+func sealDataKey(w KeyWrapper, plaintext, additionalData []byte, cfg config) (string, error) {
+	dataKey, extra, err := newDataKey(w, cfg.rand)
+	if err != nil {
+		return "", err
+	}
+	defer clear(dataKey)
+	base := envelopePrefix(modeDataKey, cfg.envelopeExtensions(dataKey))
+	header := append(base, extra...)
+	return sealEnvelope(dataKey, header, len(base), plaintext, additionalData, cfg)
+}
+
+func openDataKey(w KeyWrapper, env envelope, additionalData []byte) ([]byte, error) {
+	id, wrapped, _, _ := parseDataKeyHeader(env.fields)
+	dataKey, err := unwrapHeaderKey(w, id, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	return openAEAD(dataKey, env, additionalData)
+}
+
+func newDataKeyWriter(w KeyWrapper, out io.Writer, cfg config, sc streamConfig) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(cfg.rand, salt); err != nil {
+		return nil, err
+	}
+	dataKey, extra, err := newDataKey(w, cfg.rand)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	x := cfg.streamExtensions(sc)
+	base := streamPrefix(modeDataKey, x)
+	authHeader := append(slices.Clip(base), salt...)
+	header := append(base, extra...)
+	header = append(header, salt...)
+	key, err := deriveStreamKey(dataKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(out, key, header, authHeader, x, sc)
+}
+
+func newDataKeyReader(w KeyWrapper, r io.Reader, h streamHeader, sc streamConfig) (io.Reader, error) {
+	dataKey, err := unwrapHeaderKey(w, h.keyID, h.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	key, err := deriveStreamKey(dataKey, h.salt)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, key, h, sc)
+}
+
+// RewrapDataKey moves a data key envelope from one wrapping key to another.
+// Only the wrapped data key is replaced; the encrypted payload is copied
+// unchanged, so the payload is not authenticated until the result is opened.
+// Both envelopes are bounded by the WithMaxEnvelopeSize limit of from and to
+// if they are ciphers, and by the default limit otherwise.
+func RewrapDataKey(envelope string, from, to KeyWrapper) (string, error) {
+	if from == nil || to == nil {
+		return "", ErrUnconfigured
+	}
+	limit := rewrapLimit(from, to)
+	env, err := parseEnvelope(envelope, limit)
+	if err != nil {
+		return "", err
+	}
+	if env.mode != modeDataKey {
+		return "", fmt.Errorf("%w: envelope does not contain a data key", ErrInvalidEnvelope)
+	}
+	if env.ext.derivation != "" {
+		return "", fmt.Errorf("%w: envelope was sealed by a derived cipher", ErrInvalidEnvelope)
+	}
+	id, wrapped, _, _ := parseDataKeyHeader(env.fields)
+	extra, err := rewrapHeaderKey(id, wrapped, from, to)
+	if err != nil {
+		return "", err
+	}
+	packed := append(slices.Clip(env.auth), extra...)
+	packed = append(packed, env.nonce...)
+	packed = append(packed, env.ciphertext...)
+	if len(packed) > limit {
+		return "", ErrLimitExceeded
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(packed), nil
+}
+
+// rewrapLimit returns the smallest envelope size limit of the wrappers that
+// are ciphers.
+func rewrapLimit(wrappers ...KeyWrapper) int {
+	limit := defaultMaxEnvelope
+	for _, w := range wrappers {
+		if c, ok := w.(*Cipher); ok && c != nil && c.cfg.maxEnvelope > 0 {
+			limit = min(limit, c.cfg.maxEnvelope)
+		}
+	}
+	return limit
+}
+
+// RewrapStreamDataKey copies a data key stream from src to dst, replacing the
+// wrapped data key in its header. Records are copied unchanged and are not
+// authenticated by this call.
+func RewrapStreamDataKey(dst io.Writer, src io.Reader, from, to KeyWrapper) error {
+	if from == nil || to == nil {
+		return ErrUnconfigured
+	}
+	if dst == nil {
+		return errors.New("secure: nil writer")
+	}
+	h, err := readStreamHeader(src, modeDataKey)
+	if err != nil {
+		return err
+	}
+	if h.ext.derivation != "" {
+		return fmt.Errorf("%w: stream was sealed by a derived cipher", ErrInvalidEnvelope)
+	}
+	extra, err := rewrapHeaderKey(h.keyID, h.wrappedKey, from, to)
+	if err != nil {
+		return err
+	}
+	// h.auth holds the magic, mode, and extensions followed by the salt.
+	header := append(slices.Clip(h.auth[:len(h.auth)-saltSize]), extra...)
+	header = append(header, h.salt...)
+	if err := writeAll(dst, header); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func rewrapHeaderKey(id, wrapped []byte, from, to KeyWrapper) ([]byte, error) {
+	dataKey, err := unwrapHeaderKey(from, id, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	rewrapped, err := to.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rewrapped) == 0 || len(rewrapped) > maxWrappedKeySize {
+		return nil, fmt.Errorf("%w: wrapped key must be between 1 and %d bytes", ErrLimitExceeded, maxWrappedKeySize)
+	}
+	return dataKeyHeader(to.KeyID(), rewrapped), nil
+}
+
+func dataKeyHeader(id, wrapped []byte) []byte {
+	h := make([]byte, 0, 1+len(id)+2+len(wrapped))
+	h = append(h, byte(len(id)))
+	h = append(h, id...)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(wrapped)))
+	return append(h, wrapped...)
+}
+
+// parseDataKeyHeader decodes a key ID and wrapped data key and reports how
+// many bytes of b they occupy.
+func parseDataKeyHeader(b []byte) (id, wrapped []byte, n int, ok bool) {
+	if len(b) < 1 || b[0] == 0 || b[0] > maxKeyIDSize || len(b) < 1+int(b[0])+2 {
+		return nil, nil, 0, false
+	}
+	id = b[1 : 1+int(b[0])]
+	b = b[1+len(id):]
+	wrappedLen := int(binary.BigEndian.Uint16(b))
+	if wrappedLen == 0 || wrappedLen > maxWrappedKeySize || len(b) < 2+wrappedLen {
+		return nil, nil, 0, false
+	}
+	return id, b[2 : 2+wrappedLen], 1 + len(id) + 2 + wrappedLen, true
+}
+
+func readDataKeyHeader(r io.Reader) (id, wrapped []byte, err error) {
+	idLen := make([]byte, 1)
+	if _, err := io.ReadFull(r, idLen); err != nil {
+		return nil, nil, ErrTruncated
+	}
+	if idLen[0] == 0 || idLen[0] > maxKeyIDSize {
+		return nil, nil, ErrInvalidEnvelope
+	}
+	id = make([]byte, int(idLen[0])+2)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, nil, ErrTruncated
+	}
+	wrappedLen := int(binary.BigEndian.Uint16(id[len(id)-2:]))
+	if wrappedLen == 0 || wrappedLen > maxWrappedKeySize {
+		return nil, nil, ErrInvalidEnvelope
+	}
+	wrapped = make([]byte, wrappedLen)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return nil, nil, ErrTruncated
+	}
+	return id[:len(id)-2], wrapped, nil
+}
@@ -0,0 +1,161 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDataKeyEnvelope(t *testing.T) {
+	kek, err := NewCipher(testKey, WithKeyID([]byte("kek-1")), WithDataKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := []byte("object/42")
+	envelope, err := kek.Seal([]byte("large payload"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := EnvelopeKeyID(envelope); err != nil || string(id) != "kek-1" {
+		t.Fatalf("EnvelopeKeyID() = %q, %v", id, err)
+	}
+	if got, err := kek.Open(envelope, aad); err != nil || string(got) != "large payload" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	if _, err := kek.Open(envelope, []byte("other")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+
+	next, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize), WithKeyID([]byte("kek-2")))
+	if _, err := next.Open(envelope, aad); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("other KEK error = %v", err)
+	}
+	rewrapped, err := RewrapDataKey(envelope, kek, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := next.Open(rewrapped, aad); err != nil || string(got) != "large payload" {
+		t.Fatalf("rewrapped Open() = %q, %v", got, err)
+	}
+	oldPacked, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	newPacked, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(rewrapped, prefix))
+	if !bytes.HasSuffix(newPacked, oldPacked[len(oldPacked)-12-16-len("large payload"):]) {
+		t.Fatal("payload was re-encrypted")
+	}
+	if _, err := RewrapDataKey(envelope, next, kek); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("wrong source KEK error = %v", err)
+	}
+	plain, _ := next.EncryptString("not a data key")
+	if _, err := RewrapDataKey(plain, next, kek); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("non data key error = %v", err)
+	}
+	if _, err := RewrapDataKey(envelope, nil, kek); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil source error = %v", err)
+	}
+	small, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize), WithKeyID([]byte("kek-2")), WithMaxEnvelopeSize(len(oldPacked)-1))
+	if _, err := RewrapDataKey(envelope, kek, small); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("destination limit error = %v", err)
+	}
+	if _, err := RewrapDataKey(rewrapped, small, kek); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("source limit error = %v", err)
+	}
+
+	ring, _ := NewKeyring(next, kek)
+	if got, err := ring.Open(envelope, aad); err != nil || string(got) != "large payload" {
+		t.Fatalf("Keyring Open() = %q, %v", got, err)
+	}
+}
+
+func TestDataKeyEnvelopeTampering(t *testing.T) {
+	kek, _ := NewCipher(testKey, WithDataKeys())
+	envelope, _ := kek.EncryptString("tamper")
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	idLen := int(packed[2])
+	wrappedStart := 3 + idLen + 2
+	for _, offset := range []int{1, 3, wrappedStart, wrappedStart + 20, len(packed) - 1} {
+		mutated := append([]byte(nil), packed...)
+		mutated[offset] ^= 1
+		if _, err := kek.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(mutated)); err == nil {
+			t.Fatalf("tampering at %d was accepted", offset)
+		}
+	}
+	for _, header := range [][]byte{
+		{envelopeVersion, modeDataKey, 0},
+		{envelopeVersion, modeDataKey, 1, 'a', 0, 0},
+		{envelopeVersion, modeDataKey, 1, 'a', 0xff, 0xff},
+		{envelopeVersion, modeDataKey, 1, 'a', 0, 60},
+	} {
+		candidate := prefix + base64.RawURLEncoding.EncodeToString(append(header, make([]byte, 28)...))
+		if _, err := kek.DecryptString(candidate); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("header %x error = %v", header, err)
+		}
+	}
+	short := append([]byte{envelopeVersion, modeDataKey}, dataKeyHeader(kek.keyID, []byte{1})...)
+	short = append(short, make([]byte, 28)...)
+	if _, err := kek.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(short)); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("short wrapped key error = %v", err)
+	}
+}
+
+func TestDataKeyStream(t *testing.T) {
+	kek, _ := NewCipher(testKey, WithKeyID([]byte("kek-1")), WithDataKeys())
+	input := bytes.Repeat([]byte("stream"), streamChunkSize/3)
+	var encrypted bytes.Buffer
+	w, err := kek.NewEncryptWriter(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := kek.NewDecryptReader(bytes.NewReader(encrypted.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("ReadAll() = %d bytes, %v", len(got), err)
+	}
+
+	next, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize), WithKeyID([]byte("kek-2")))
+	if _, err := next.NewDecryptReader(bytes.NewReader(encrypted.Bytes())); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("other KEK error = %v", err)
+	}
+	var rewrapped bytes.Buffer
+	if err := RewrapStreamDataKey(&rewrapped, bytes.NewReader(encrypted.Bytes()), kek, next); err != nil {
+		t.Fatal(err)
+	}
+	r, err = next.NewDecryptReader(bytes.NewReader(rewrapped.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("rewrapped ReadAll() = %d bytes, %v", len(got), err)
+	}
+
+	if err := RewrapStreamDataKey(nil, bytes.NewReader(encrypted.Bytes()), kek, next); err == nil {
+		t.Fatal("accepted nil writer")
+	}
+	if err := RewrapStreamDataKey(io.Discard, bytes.NewReader(encrypted.Bytes()), next, kek); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("wrong source KEK error = %v", err)
+	}
+	if err := RewrapStreamDataKey(io.Discard, bytes.NewReader(encrypted.Bytes()), kek, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil destination error = %v", err)
+	}
+	for _, cut := range []int{len(streamMagic) + 1, len(streamMagic) + 3, len(streamMagic) + 10} {
+		if _, err := kek.NewDecryptReader(bytes.NewReader(encrypted.Bytes()[:cut])); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("cut %d error = %v", cut, err)
+		}
+	}
+	for _, header := range [][]byte{{0}, {1, 'a', 0, 0}} {
+		data := append(append([]byte(streamMagic), modeDataKey), header...)
+		if _, err := kek.NewDecryptReader(bytes.NewReader(data)); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("header %x error = %v", header, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// maxDerivationSize bounds the encoded derivation path: one length byte
+// followed by the context for every level of derivation.
+const maxDerivationSize = 255
+
+// Derive returns a child Cipher whose key is derived from c's key and context
+// with HKDF-SHA256, for example to give every tenant its own key under one
+// master key. A leaked child key exposes only that child's data, and children
+// may be derived again.
+//
+// The child inherits c's options and key ID and records the derivation
+// contexts in the clear in every envelope and stream header, and in its
+// MultiRecipient stanzas, so c and its ancestors open the child's data by
+// deriving the child again. A child cannot open data sealed by its parent or
+// by another child and returns ErrKeyMismatch. Data key envelopes sealed by a
+// child cannot be rewrapped.
+func (c *Cipher) Derive(context []byte) (*Cipher, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if len(context) == 0 || len(c.cfg.derivation)+1+len(context) > maxDerivationSize {
+		return nil, fmt.Errorf("%w: derivation contexts must be non-empty and at most %d bytes in total", ErrLimitExceeded, maxDerivationSize-1)
+	}
+	child := &Cipher{keyID: c.keyID, cfg: c.cfg}
+	child.cfg.derivation = c.cfg.derivation + string(byte(len(context))) + string(context)
+	r := hkdf.New(sha256.New, c.key[:], nil, append([]byte("github.com/rusq/secure/v2 derive "), context...))
+	if _, err := io.ReadFull(r, child.key[:]); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// derived returns the cipher for data recorded with the derivation path,
+// deriving it from c if c is one of its ancestors.
+func (c *Cipher) derived(path string) (*Cipher, error) {
+	if !strings.HasPrefix(path, c.cfg.derivation) {
+		return nil, ErrKeyMismatch
+	}
+	d := c
+	for rest := path[len(c.cfg.derivation):]; rest != ""; {
+		n := int(rest[0])
+		child, err := d.Derive([]byte(rest[1 : 1+n]))
+		if err != nil {
+			return nil, err
+		}
+		d, rest = child, rest[1+n:]
+	}
+	return d, nil
+}
+
+// validDerivation reports whether path is a sequence of non-empty,
+// length-prefixed contexts.
+func validDerivation(path []byte) bool {
+	if len(path) == 0 || len(path) > maxDerivationSize {
+		return false
+	}
+	for len(path) > 0 {
+		n := int(path[0])
+		if n == 0 || len(path) < 1+n {
+			return false
+		}
+		path = path[1+n:]
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDeriveOpensFromParent(t *testing.T) {
+	master, _ := NewCipher(testKey)
+	tenant, err := master.Derive([]byte("tenant-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HKDF-SHA256(testKey, info = "github.com/rusq/secure/v2 derive tenant-1")
+	if got := hex.EncodeToString(tenant.key[:]); got != "06e42ceee381adce2f9b7ad4178c74964d904c8d3afe4f3abe77f5a346c363e4" {
+		t.Fatalf("derived key %s", got)
+	}
+	envelope, err := tenant.Seal([]byte("tenant secret"), []byte("row 7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	if packed[1] != modeKey|flagExtensions || packed[4] != extDerivation || string(packed[7:16]) != "\x08tenant-1" {
+		t.Fatalf("header % x does not record the derivation", packed[:16])
+	}
+	for name, c := range map[string]*Cipher{"parent": master, "child": tenant} {
+		if got, err := c.Open(envelope, []byte("row 7")); err != nil || string(got) != "tenant secret" {
+			t.Fatalf("%s: Open() = %q, %v", name, got, err)
+		}
+	}
+	if _, err := master.Open(envelope, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, _ := tenant.NewEncryptWriter(&buf)
+	w.Write([]byte("tenant stream"))
+	w.Close()
+	r, err := master.NewDecryptReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "tenant stream" {
+		t.Fatalf("stream = %q, %v", got, err)
+	}
+}
+
+func TestDeriveIsolatesTenants(t *testing.T) {
+	master, _ := NewCipher(testKey)
+	a, _ := master.Derive([]byte("tenant-a"))
+	b, _ := master.Derive([]byte("tenant-b"))
+	fromA, _ := a.EncryptString("a")
+	fromB, _ := b.EncryptString("b")
+	fromMaster, _ := master.EncryptString("m")
+	for name, tc := range map[string]struct {
+		c        *Cipher
+		envelope string
+	}{
+		"sibling": {b, fromA},
+		"parent":  {a, fromMaster},
+	} {
+		if _, err := tc.c.DecryptString(tc.envelope); !errors.Is(err, ErrKeyMismatch) {
+			t.Fatalf("%s error = %v", name, err)
+		}
+	}
+	var buf bytes.Buffer
+	w, _ := master.NewEncryptWriter(&buf)
+	w.Close()
+	if _, err := a.NewDecryptReader(&buf); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("parent stream error = %v", err)
+	}
+
+	// A leaked child key opens neither its siblings nor its parent.
+	leaked, _ := NewCipher(a.key[:])
+	for _, envelope := range []string{fromB, fromMaster} {
+		if _, err := leaked.DecryptString(envelope); err == nil {
+			t.Fatal("leaked child key opened another tenant")
+		}
+	}
+	if bytes.Equal(a.key[:], b.key[:]) || bytes.Equal(a.key[:], testKey) {
+		t.Fatal("derived keys are not distinct")
+	}
+}
+
+func TestDeriveNested(t *testing.T) {
+	master, _ := NewCipher(testKey, WithKeyID([]byte("master")))
+	tenant, _ := master.Derive([]byte("tenant-1"))
+	purpose, err := tenant.Derive([]byte("billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, _ := purpose.EncryptString("nested")
+	if id, _ := EnvelopeKeyID(envelope); string(id) != "master" {
+		t.Fatalf("EnvelopeKeyID() = %q", id)
+	}
+	ring, _ := NewKeyring(master)
+	for name, c := range map[string]Codec{"master": master, "tenant": tenant, "purpose": purpose, "keyring": ring} {
+		if got, err := c.Open(envelope, nil); err != nil || string(got) != "nested" {
+			t.Fatalf("%s: Open() = %q, %v", name, got, err)
+		}
+	}
+	other, _ := master.Derive([]byte("tenant-2"))
+	if _, err := other.DecryptString(envelope); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("other tenant error = %v", err)
+	}
+}
+
+func TestDeriveDataKeysAndRecipients(t *testing.T) {
+	master, _ := NewCipher(testKey, WithDataKeys())
+	tenant, _ := master.Derive([]byte("tenant-1"))
+	envelope, err := tenant.EncryptString("wrapped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := master.DecryptString(envelope); err != nil || got != "wrapped" {
+		t.Fatalf("Open(data key) = %q, %v", got, err)
+	}
+	next, _ := NewCipher(bytes.Repeat([]byte{0x24}, keySize))
+	if _, err := RewrapDataKey(envelope, tenant, next); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("RewrapDataKey(derived) error = %v", err)
+	}
+	var buf bytes.Buffer
+	w, _ := tenant.NewEncryptWriter(&buf)
+	w.Close()
+	if err := RewrapStreamDataKey(io.Discard, &buf, tenant, next); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("RewrapStreamDataKey(derived) error = %v", err)
+	}
+
+	// A child used as a multi-recipient opens with its own key.
+	m, _ := NewMultiRecipient([]Recipient{tenant})
+	shared, _ := m.Seal([]byte("shared"), nil)
+	if got, err := tenant.Open(shared, nil); err != nil || string(got) != "shared" {
+		t.Fatalf("Open(multi) = %q, %v", got, err)
+	}
+}
+
+func TestDeriveMultiRecipient(t *testing.T) {
+	master, _ := NewCipher(testKey, WithKeyID([]byte("master")))
+	tenant, _ := master.Derive([]byte("tenant-1"))
+	sibling, _ := master.Derive([]byte("tenant-2"))
+	for name, recipients := range map[string][]Recipient{
+		"child first":  {tenant, master},
+		"parent first": {master, tenant},
+		"child only":   {tenant},
+	} {
+		m, _ := NewMultiRecipient(recipients)
+		envelope, err := m.Seal([]byte("shared"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for opener, c := range map[string]*Cipher{"parent": master, "child": tenant} {
+			if got, err := c.Open(envelope, nil); err != nil || string(got) != "shared" {
+				t.Fatalf("%s: %s Open() = %q, %v", name, opener, got, err)
+			}
+		}
+		if _, err := sibling.Open(envelope, nil); !errors.Is(err, ErrKeyMismatch) {
+			t.Fatalf("%s: sibling error = %v", name, err)
+		}
+		var buf bytes.Buffer
+		w, _ := m.NewEncryptWriter(&buf)
+		w.Write([]byte("stream"))
+		w.Close()
+		r, err := master.NewDecryptReader(&buf)
+		if err != nil {
+			t.Fatalf("%s: parent NewDecryptReader: %v", name, err)
+		}
+		if got, err := io.ReadAll(r); err != nil || string(got) != "stream" {
+			t.Fatalf("%s: parent stream = %q, %v", name, got, err)
+		}
+	}
+
+	// A parent stanza does not stop the child with ErrAuthentication.
+	m, _ := NewMultiRecipient([]Recipient{master})
+	envelope, _ := m.Seal([]byte("parent only"), nil)
+	if _, err := tenant.Open(envelope, nil); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("child opening parent stanza error = %v", err)
+	}
+}
+
+func TestDeriveValidation(t *testing.T) {
+	master, _ := NewCipher(testKey)
+	if _, err := master.Derive(nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("empty context error = %v", err)
+	}
+	if _, err := master.Derive(make([]byte, maxDerivationSize)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("long context error = %v", err)
+	}
+	if _, err := master.Derive(make([]byte, maxDerivationSize-1)); err != nil {
+		t.Fatalf("context at limit: %v", err)
+	}
+	var unconfigured *Cipher
+	if _, err := unconfigured.Derive([]byte("x")); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil Derive error = %v", err)
+	}
+
+	for _, path := range []string{"", "\x00", "\x02a", "\x01a\x00"} {
+		if validDerivation([]byte(path)) {
+			t.Fatalf("validDerivation(%q) = true", path)
+		}
+	}
+	envelope, _ := master.EncryptString("x")
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	bad := append([]byte{packed[0], packed[1] | flagExtensions, 0, 5, extDerivation, 0, 2, 2, 'a'}, packed[2:]...)
+	if _, err := master.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(bad)); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("malformed derivation error = %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package secure
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// DeterministicCipher seals with AES-SIV (RFC 5297) instead of a random
+// nonce, so the same plaintext sealed with the same additional data always
+// produces the same envelope. This allows exact-match lookups on encrypted
+// columns, such as finding a user by an encrypted email address.
+//
+// Determinism leaks equality: anyone who can see envelopes learns which of
+// them hold the same value, and can confirm a guessed value if they can get it
+// sealed. Use it only for fields that must be searched, give each field its
+// own key or additional data so equal values in different columns do not
+// match, and prefer Cipher everywhere else. A DeterministicCipher opens only
+// its own envelopes.
+type DeterministicCipher struct {
+	aead cipher.AEAD
+	cfg  config
+}
+
+// NewDeterministicCipher creates a deterministic encryption context. key must
+// contain 32 bytes. The AES-SIV keys are derived from it, so key may be shared
+// with a Cipher. WithAlgorithm, WithIssuedAt, WithExpiry, WithKeyID,
+// WithDerivedKeyID, WithDataKeys, and WithKeyCommitment are not supported.
+func NewDeterministicCipher(key []byte, opts ...Option) (*DeterministicCipher, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("secure: key must be %d bytes", keySize)
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.alg != AES256GCM {
+		return nil, errors.New("secure: DeterministicCipher always uses AES-SIV")
+	}
+	if cfg.issuedAt || cfg.ttl > 0 {
+		return nil, errors.New("secure: deterministic envelopes cannot record times")
+	}
+	if cfg.embedKeyID || cfg.dataKeys || cfg.commit {
+		return nil, errors.New("secure: deterministic envelopes cannot record key IDs, data keys, or commitments")
+	}
+	sivKey := make([]byte, 2*keySize)
+	defer clear(sivKey)
+	r := hkdf.New(sha256.New, key, nil, []byte("github.com/rusq/secure/v2 deterministic"))
+	if _, err := io.ReadFull(r, sivKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAESSIV(sivKey)
+	if err != nil {
+		return nil, err
+	}
+	return &DeterministicCipher{aead: aead, cfg: cfg}, nil
+}
+
+func (d *DeterministicCipher) validate() error {
+	if d == nil || d.aead == nil || d.cfg.maxEnvelope == 0 {
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// Seal encrypts plaintext and authenticates additionalData without storing
+// it. Equal inputs produce equal envelopes.
+func (d *DeterministicCipher) Seal(plaintext, additionalData []byte) (string, error) {
+	if err := d.validate(); err != nil {
+		return "", err
+	}
+	header := envelopePrefix(modeDeterministic, defaultExtensions())
+	return sealAEAD(d.aead, header, len(header), plaintext, additionalData, d.cfg)
+}
+
+// Open authenticates and decrypts a deterministic SEC2 envelope.
+func (d *DeterministicCipher) Open(envelope string, additionalData []byte) ([]byte, error) {
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	if len(additionalData) > d.cfg.maxEnvelope {
+		return nil, ErrLimitExceeded
+	}
+	env, err := parseEnvelope(envelope, d.cfg.maxEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	if env.mode != modeDeterministic {
+		return nil, fmt.Errorf("%w: envelope is not deterministic", ErrInvalidEnvelope)
+	}
+	plaintext, err := d.aead.Open(nil, nil, env.ciphertext, envelopeAAD(env.auth, additionalData))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
+
+// EncryptString encrypts a UTF-8 string without additional data.
+func (d *DeterministicCipher) EncryptString(plaintext string) (string, error) {
+	return d.Seal([]byte(plaintext), nil)
+}
+
+// DecryptString decrypts a UTF-8 string without additional data.
+func (d *DeterministicCipher) DecryptString(envelope string) (string, error) {
+	b, err := d.Open(envelope, nil)
+	return string(b), err
+}
@@ -0,0 +1,157 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDeterministicCipher(t *testing.T) {
+	d, err := NewDeterministicCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := []byte("users/email")
+	first, err := d.Seal([]byte("alice@example.com"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := d.Seal([]byte("alice@example.com"), aad)
+	if first != second {
+		t.Fatalf("equal inputs sealed differently: %q, %q", first, second)
+	}
+	if !strings.HasPrefix(first, prefix) {
+		t.Fatalf("unexpected envelope: %q", first)
+	}
+	for name, other := range map[string]func() (string, error){
+		"plaintext": func() (string, error) { return d.Seal([]byte("bob@example.com"), aad) },
+		"AAD":       func() (string, error) { return d.Seal([]byte("alice@example.com"), []byte("users/backup")) },
+	} {
+		if envelope, err := other(); err != nil || envelope == first {
+			t.Fatalf("different %s gave %q, %v", name, envelope, err)
+		}
+	}
+	got, err := d.Open(first, aad)
+	if err != nil || string(got) != "alice@example.com" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	if _, err := d.Open(first, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+
+	text, err := d.EncryptString("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.DecryptString(text); err != nil || got != "" {
+		t.Fatalf("DecryptString() = %q, %v", got, err)
+	}
+
+	other, _ := NewDeterministicCipher(bytes.Repeat([]byte{0x24}, keySize))
+	if envelope, _ := other.Seal([]byte("alice@example.com"), aad); envelope == first {
+		t.Fatal("different keys produced equal envelopes")
+	}
+	if _, err := other.Open(first, aad); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong key error = %v", err)
+	}
+}
+
+func TestDeterministicCipherModeSeparation(t *testing.T) {
+	d, _ := NewDeterministicCipher(testKey)
+	c, _ := NewCipher(testKey)
+	randomized, _ := c.Seal([]byte("value"), nil)
+	if _, err := d.Open(randomized, nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("Open(Cipher envelope) error = %v", err)
+	}
+	deterministic, _ := d.Seal([]byte("value"), nil)
+	if _, err := c.Open(deterministic, nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("Cipher.Open(deterministic envelope) error = %v", err)
+	}
+
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(deterministic, prefix))
+	if packed[1] != modeDeterministic || len(packed) != 2+sivSize+len("value") {
+		t.Fatalf("unexpected layout %x", packed)
+	}
+	for i := range packed {
+		tampered := append([]byte(nil), packed...)
+		tampered[i] ^= 1
+		if _, err := d.Open(prefix+base64.RawURLEncoding.EncodeToString(tampered), nil); err == nil {
+			t.Fatalf("tampering at %d was accepted", i)
+		}
+	}
+	// An algorithm extension is never written in this mode and is rejected.
+	withAlg := append([]byte{envelopeVersion, modeDeterministic | flagExtensions, 0, 4, extAlgorithm, 0, 1, byte(XChaCha20Poly1305)}, packed[2:]...)
+	if _, err := d.Open(prefix+base64.RawURLEncoding.EncodeToString(withAlg), nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("algorithm extension error = %v", err)
+	}
+	if _, err := d.Open(prefix+base64.RawURLEncoding.EncodeToString(packed[:2+sivSize-1]), nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("short envelope error = %v", err)
+	}
+}
+
+func TestDeterministicCipherConfiguration(t *testing.T) {
+	if _, err := NewDeterministicCipher(testKey[:16]); err == nil {
+		t.Fatal("accepted short key")
+	}
+	for name, opt := range map[string]Option{
+		"WithAlgorithm":     WithAlgorithm(XChaCha20Poly1305),
+		"WithIssuedAt":      WithIssuedAt(),
+		"WithKeyID":         WithKeyID([]byte("k1")),
+		"WithDerivedKeyID":  WithDerivedKeyID(),
+		"WithDataKeys":      WithDataKeys(),
+		"WithKeyCommitment": WithKeyCommitment(),
+	} {
+		if _, err := NewDeterministicCipher(testKey, opt); err == nil {
+			t.Fatalf("accepted %s", name)
+		}
+	}
+	if _, err := NewDeterministicCipher(testKey, nil); err == nil {
+		t.Fatal("accepted nil option")
+	}
+	var unconfigured *DeterministicCipher
+	if _, err := unconfigured.Seal(nil, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil Seal error = %v", err)
+	}
+	if _, err := (&DeterministicCipher{}).Open("", nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("zero Open error = %v", err)
+	}
+
+	d, _ := NewDeterministicCipher(testKey, WithMaxEnvelopeSize(64))
+	if _, err := d.Seal(make([]byte, 64-2-sivSize), nil); err != nil {
+		t.Fatalf("Seal at limit: %v", err)
+	}
+	if _, err := d.Seal(make([]byte, 64-2-sivSize+1), nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Seal over limit error = %v", err)
+	}
+	if _, err := d.Open("SEC2.AAA", make([]byte, 65)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("Open with large AAD error = %v", err)
+	}
+	if _, err := d.Open("SEC2.!", nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("Open(invalid) error = %v", err)
+	}
+}
+
+func TestDeterministicEncryptedStringLookup(t *testing.T) {
+	d, _ := NewDeterministicCipher(testKey)
+	type user struct {
+		Email EncryptedString `json:"email"`
+	}
+	email, _ := NewEncryptedString(d, "alice@example.com")
+	stored, err := json.Marshal(user{Email: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A lookup seals the search term and compares envelopes.
+	term, _ := d.EncryptString("alice@example.com")
+	if want, _ := json.Marshal(map[string]string{"email": term}); !bytes.Equal(stored, want) {
+		t.Fatalf("stored %s, lookup %s", stored, want)
+	}
+	decoded, _ := NewEncryptedString(d, "")
+	u := user{Email: decoded}
+	if err := json.Unmarshal(stored, &u); err != nil || u.Email.Value() != "alice@example.com" {
+		t.Fatalf("Unmarshal() = %q, %v", u.Email.Value(), err)
+	}
+}
@@ -9,4 +9,6 @@ var (
 	ErrLimitExceeded      = errors.New("secure: configured limit exceeded")
 	ErrTruncated          = errors.New("secure: encrypted stream truncated")
 	ErrUnconfigured       = errors.New("secure: value is not configured")
+	ErrKeyMismatch        = errors.New("secure: envelope was sealed with a different key")
+	ErrExpired            = errors.New("secure: envelope has expired")
 )
@@ -0,0 +1,83 @@
+package secure
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WithIssuedAt records the time an envelope is sealed in its authenticated
+// header. Times are stored with one-second precision. Streams are not
+// affected.
+func WithIssuedAt() Option {
+	return func(c *config) error {
+		c.issuedAt = true
+		return nil
+	}
+}
+
+// WithExpiry records a not-after time ttl after sealing in every envelope's
+// authenticated header. Open rejects the envelope with ErrExpired once that
+// time has passed, after the envelope has been authenticated. Streams are
+// not affected.
+func WithExpiry(ttl time.Duration) Option {
+	return func(c *config) error {
+		if ttl < time.Second {
+			return fmt.Errorf("%w: expiry must be at least one second", ErrLimitExceeded)
+		}
+		c.ttl = ttl
+		return nil
+	}
+}
+
+// WithClock replaces time.Now for recording and checking envelope times.
+func WithClock(now func() time.Time) Option {
+	return func(c *config) error {
+		if now == nil {
+			return errors.New("secure: nil clock")
+		}
+		c.clock = now
+		return nil
+	}
+}
+
+// WithPasswordIssuedAt records the sealing time in password envelopes.
+func WithPasswordIssuedAt() PasswordOption {
+	return func(c *passwordConfig) error {
+		return WithIssuedAt()(&c.config)
+	}
+}
+
+// WithPasswordExpiry records a not-after time in password envelopes.
+func WithPasswordExpiry(ttl time.Duration) PasswordOption {
+	return func(c *passwordConfig) error {
+		return WithExpiry(ttl)(&c.config)
+	}
+}
+
+// WithPasswordClock replaces time.Now for a PasswordCipher.
+func WithPasswordClock(now func() time.Time) PasswordOption {
+	return func(c *passwordConfig) error {
+		return WithClock(now)(&c.config)
+	}
+}
+
+func (c config) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock()
+}
+
+// checkExpiry passes through the result of opening env unless env has
+// expired, in which case the plaintext is cleared and ErrExpired returned.
+func (c config) checkExpiry(env envelope, plaintext []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if env.ext.hasNotAfter && c.now().Unix() > env.ext.notAfter {
+		clear(plaintext)
+		return nil, ErrExpired
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,159 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time { return c.t }
+
+func TestEnvelopeExpiry(t *testing.T) {
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	c, err := NewCipher(testKey, WithIssuedAt(), WithExpiry(time.Hour), WithClock(clock.now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := c.Seal([]byte("reset token"), []byte("user 7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	if packed[1] != modeKey|flagExtensions || packed[4] != extIssuedAt || packed[15] != extNotAfter {
+		t.Fatalf("header % x does not record times", packed[:26])
+	}
+	if issued, notAfter := binary.BigEndian.Uint64(packed[7:15]), binary.BigEndian.Uint64(packed[18:26]); issued != 1_600_000_000 || notAfter != 1_600_003_600 {
+		t.Fatalf("issued at %d, not after %d", issued, notAfter)
+	}
+
+	clock.t = clock.t.Add(time.Hour)
+	if got, err := c.Open(envelope, []byte("user 7")); err != nil || string(got) != "reset token" {
+		t.Fatalf("Open() at the not-after time = %q, %v", got, err)
+	}
+	clock.t = clock.t.Add(time.Second)
+	if got, err := c.Open(envelope, []byte("user 7")); !errors.Is(err, ErrExpired) || got != nil {
+		t.Fatalf("Open() after expiry = %q, %v", got, err)
+	}
+	// Forged envelopes fail authentication before the expiry is checked.
+	if _, err := c.Open(envelope, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+	binary.BigEndian.PutUint64(packed[18:26], 1<<40)
+	if _, err := c.Open(prefix+base64.RawURLEncoding.EncodeToString(packed), []byte("user 7")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("extended expiry error = %v", err)
+	}
+
+	// Expiry is enforced by the opener's clock whatever its own options.
+	plain, _ := NewCipher(testKey)
+	if _, err := plain.Open(envelope, []byte("user 7")); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Open() with time.Now error = %v", err)
+	}
+	if _, err := Rewrap(envelope, plain, plain, []byte("user 7")); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Rewrap() error = %v", err)
+	}
+	issuedOnly, _ := NewCipher(testKey, WithIssuedAt())
+	envelope, _ = issuedOnly.EncryptString("no expiry")
+	if got, err := plain.DecryptString(envelope); err != nil || got != "no expiry" {
+		t.Fatalf("Open(issued at only) = %q, %v", got, err)
+	}
+}
+
+func TestExpiryAcrossCodecs(t *testing.T) {
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	opts := []Option{WithExpiry(time.Minute), WithClock(clock.now)}
+	c, _ := NewCipher(testKey, opts...)
+	ring, _ := NewKeyring(c)
+	wrapper, _ := NewCipher(bytes.Repeat([]byte{0x24}, keySize))
+	e, _ := NewEnvelopeCipher(wrapper, opts...)
+	x, _ := GenerateX25519Identity(opts...)
+	hybrid, _ := GenerateHybridIdentity(opts...)
+	password, _ := NewPasswordCipher([]byte("correct horse"), WithPasswordExpiry(time.Minute), WithPasswordClock(clock.now))
+	multi, _ := NewMultiRecipient([]Recipient{c}, opts...)
+	for name, tc := range map[string]struct {
+		seal interface {
+			Seal(plaintext, additionalData []byte) (string, error)
+		}
+		open Codec
+	}{
+		"keyring":  {ring, ring},
+		"wrapper":  {e, e},
+		"x25519":   {x, x},
+		"hybrid":   {hybrid, hybrid},
+		"password": {password, password},
+		"multi":    {multi, c},
+	} {
+		clock.t = time.Unix(1_600_000_000, 0)
+		envelope, err := tc.seal.Seal([]byte("payload"), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := tc.open.Open(envelope, nil); err != nil || string(got) != "payload" {
+			t.Fatalf("%s: Open() = %q, %v", name, got, err)
+		}
+		clock.t = clock.t.Add(time.Hour)
+		if _, err := tc.open.Open(envelope, nil); !errors.Is(err, ErrExpired) {
+			t.Fatalf("%s: Open() after expiry error = %v", name, err)
+		}
+	}
+}
+
+func TestExpiringEncryptedString(t *testing.T) {
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	c, _ := NewCipher(testKey, WithExpiry(15*time.Minute), WithClock(clock.now))
+	token, _ := NewEncryptedString(c, "invite:42")
+	data, err := json.Marshal(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := NewEncryptedString(c, "")
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Value() != "invite:42" {
+		t.Fatalf("Unmarshal() = %q, %v", decoded.Value(), err)
+	}
+	clock.t = clock.t.Add(16 * time.Minute)
+	if err := json.Unmarshal(data, &decoded); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Unmarshal() after expiry error = %v", err)
+	}
+}
+
+func TestExpiryOptions(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"zero expiry": WithExpiry(0),
+		"sub-second":  WithExpiry(time.Millisecond),
+		"nil clock":   WithClock(nil),
+	} {
+		if _, err := NewCipher(testKey, opt); err == nil {
+			t.Fatalf("%s was accepted", name)
+		}
+	}
+	if _, err := NewPasswordCipher([]byte("x"), WithPasswordIssuedAt(), WithPasswordExpiry(-time.Hour)); err == nil {
+		t.Fatal("negative password expiry was accepted")
+	}
+	for _, opt := range []Option{WithIssuedAt(), WithExpiry(time.Hour)} {
+		if _, err := NewDeterministicCipher(testKey, opt); err == nil {
+			t.Fatal("deterministic cipher accepted a time option")
+		}
+	}
+
+	// Streams are unaffected.
+	c, _ := NewCipher(testKey, WithIssuedAt(), WithExpiry(time.Hour))
+	var buf bytes.Buffer
+	w, _ := c.NewEncryptWriter(&buf)
+	w.Close()
+	if buf.Bytes()[len(streamMagic)] != modeKey {
+		t.Fatal("stream header records times")
+	}
+
+	envelope, _ := c.EncryptString("x")
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	short := append([]byte{packed[0], packed[1], 0, 10, extIssuedAt, 0, 7}, packed[8:]...)
+	if _, err := c.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(short)); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("short time error = %v", err)
+	}
+}
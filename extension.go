@@ -0,0 +1,258 @@
+package secure
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// A mode byte with flagExtensions set is followed by a two-byte length and
+// a block of header extensions, each encoded as type(1) length(2) value.
+// Extensions are part of the authenticated header. Unknown or repeated
+// extensions are rejected, since they may change how the payload must be
+// decrypted.
+const (
+	flagExtensions = byte(0x80)
+
+	extAlgorithm  = byte(1)
+	extCommitment = byte(2)
+	extDerivation = byte(3)
+	extIssuedAt   = byte(4)
+	extNotAfter   = byte(5)
+	extMetadata   = byte(6)
+	extRecordSize = byte(7)
+	extRecords    = byte(8)
+)
+
+// extensions holds the optional header fields. Fields with their default
+// value are not encoded, so headers written with the defaults are identical
+// to those written before extensions existed.
+type extensions struct {
+	alg        Algorithm
+	committed  bool
+	commitment [commitmentSize]byte
+	derivation string
+
+	// Unix times in seconds, present only in envelopes.
+	hasIssuedAt, hasNotAfter bool
+	issuedAt, notAfter       int64
+	metadata                 string
+
+	// Plaintext bytes per record, present only in streams; zero means
+	// streamChunkSize.
+	recordSize uint32
+	// The flags of the optional records of a stream.
+	records byte
+}
+
+func defaultExtensions() extensions {
+	return extensions{alg: AES256GCM}
+}
+
+// extensions returns the header fields for new streams.
+func (c config) extensions() extensions {
+	return extensions{alg: c.alg, derivation: c.derivation}
+}
+
+// streamExtensions returns the header fields for a new stream written with
+// sc.
+func (c config) streamExtensions(sc streamConfig) extensions {
+	x := c.extensions()
+	if sc.recordSize != streamChunkSize {
+		x.recordSize = uint32(sc.recordSize)
+	}
+	if sc.metadata != nil {
+		x.records |= streamMetadata
+	}
+	if sc.trailer {
+		x.records |= streamTrailer
+	}
+	return x
+}
+
+// chunkSize returns the plaintext bytes per record of a stream.
+func (x extensions) chunkSize() int {
+	if x.recordSize == 0 {
+		return streamChunkSize
+	}
+	return int(x.recordSize)
+}
+
+// envelopeExtensions returns the header fields for a new envelope whose
+// payload is encrypted under key.
+func (c config) envelopeExtensions(key []byte) extensions {
+	x := c.extensions()
+	if c.commit {
+		x.committed, x.commitment = true, keyCommitment(key)
+	}
+	x.metadata = c.metadata
+	if c.times != nil {
+		x.hasIssuedAt, x.issuedAt = c.times.hasIssuedAt, c.times.issuedAt
+		x.hasNotAfter, x.notAfter = c.times.hasNotAfter, c.times.notAfter
+	} else if c.issuedAt || c.ttl > 0 {
+		now := c.now()
+		if c.issuedAt {
+			x.hasIssuedAt, x.issuedAt = true, now.Unix()
+		}
+		if c.ttl > 0 {
+			x.hasNotAfter, x.notAfter = true, now.Add(c.ttl).Unix()
+		}
+	}
+	return x
+}
+
+// appendModeExtensions appends the mode byte and, if any field differs from
+// its default, the encoded extension block.
+func appendModeExtensions(b []byte, mode byte, x extensions) []byte {
+	var block []byte
+	if x.alg != AES256GCM {
+		block = appendExtension(block, extAlgorithm, []byte{byte(x.alg)})
+	}
+	if x.committed {
+		block = appendExtension(block, extCommitment, x.commitment[:])
+	}
+	if x.derivation != "" {
+		block = appendExtension(block, extDerivation, []byte(x.derivation))
+	}
+	if x.hasIssuedAt {
+		block = appendExtension(block, extIssuedAt, binary.BigEndian.AppendUint64(nil, uint64(x.issuedAt)))
+	}
+	if x.hasNotAfter {
+		block = appendExtension(block, extNotAfter, binary.BigEndian.AppendUint64(nil, uint64(x.notAfter)))
+	}
+	if x.metadata != "" {
+		block = appendExtension(block, extMetadata, []byte(x.metadata))
+	}
+	if x.recordSize != 0 {
+		block = appendExtension(block, extRecordSize, binary.BigEndian.AppendUint32(nil, x.recordSize))
+	}
+	if x.records != 0 {
+		block = appendExtension(block, extRecords, []byte{x.records})
+	}
+	if block == nil {
+		return append(b, mode)
+	}
+	b = append(b, mode|flagExtensions)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(block)))
+	return append(b, block...)
+}
+
+func appendExtension(b []byte, kind byte, value []byte) []byte {
+	b = append(b, kind)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// envelopePrefix returns the version, mode, and extensions of a SEC2 header.
+func envelopePrefix(mode byte, x extensions) []byte {
+	return appendModeExtensions([]byte{envelopeVersion}, mode, x)
+}
+
+// streamPrefix returns the magic, mode, and extensions of a SECS2 header.
+func streamPrefix(mode byte, x extensions) []byte {
+	return appendModeExtensions([]byte(streamMagic), mode, x)
+}
+
+// parseExtensionBlock decodes a length-prefixed extension block and reports
+// how many bytes of b it occupies.
+func parseExtensionBlock(b []byte) (x extensions, n int, ok bool) {
+	if len(b) < 2 {
+		return x, 0, false
+	}
+	size := int(binary.BigEndian.Uint16(b))
+	if size == 0 || len(b) < 2+size {
+		return x, 0, false
+	}
+	x, ok = parseExtensions(b[2 : 2+size])
+	return x, 2 + size, ok
+}
+
+// readExtensionBlock reads a length-prefixed extension block from a stream
+// header and returns it along with its encoded form.
+func readExtensionBlock(r io.Reader) (extensions, []byte, error) {
+	raw := make([]byte, 2)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return extensions{}, nil, ErrTruncated
+	}
+	size := int(binary.BigEndian.Uint16(raw))
+	if size == 0 {
+		return extensions{}, nil, ErrInvalidEnvelope
+	}
+	raw = append(raw, make([]byte, size)...)
+	if _, err := io.ReadFull(r, raw[2:]); err != nil {
+		return extensions{}, nil, ErrTruncated
+	}
+	x, ok := parseExtensions(raw[2:])
+	if !ok {
+		return extensions{}, nil, ErrInvalidEnvelope
+	}
+	return x, raw, nil
+}
+
+func parseExtensions(b []byte) (extensions, bool) {
+	x := defaultExtensions()
+	var seen [256]bool
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return x, false
+		}
+		kind := b[0]
+		size := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+size || seen[kind] {
+			return x, false
+		}
+		seen[kind] = true
+		value := b[3 : 3+size]
+		switch kind {
+		case extAlgorithm:
+			if size != 1 || !Algorithm(value[0]).valid() {
+				return x, false
+			}
+			x.alg = Algorithm(value[0])
+		case extCommitment:
+			if size != commitmentSize {
+				return x, false
+			}
+			x.committed = true
+			copy(x.commitment[:], value)
+		case extDerivation:
+			if !validDerivation(value) {
+				return x, false
+			}
+			x.derivation = string(value)
+		case extIssuedAt, extNotAfter:
+			if size != 8 {
+				return x, false
+			}
+			t := int64(binary.BigEndian.Uint64(value))
+			if kind == extIssuedAt {
+				x.hasIssuedAt, x.issuedAt = true, t
+			} else {
+				x.hasNotAfter, x.notAfter = true, t
+			}
+		case extMetadata:
+			if _, ok := decodeMetadata(value); !ok {
+				return x, false
+			}
+			x.metadata = string(value)
+		case extRecordSize:
+			if size != 4 {
+				return x, false
+			}
+			// The default size is never encoded.
+			n := binary.BigEndian.Uint32(value)
+			if n < minStreamRecordSize || n > maxStreamRecordSize || n == streamChunkSize {
+				return x, false
+			}
+			x.recordSize = n
+		case extRecords:
+			if size != 1 || value[0] == 0 || value[0]&^(streamMetadata|streamTrailer) != 0 {
+				return x, false
+			}
+			x.records = value[0]
+		default:
+			return x, false
+		}
+		b = b[3+size:]
+	}
+	return x, true
+}
@@ -0,0 +1,212 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	gcmSIVNonceSize = 12
+	gcmSIVTagSize   = 16
+)
+
+// gcmSIV implements AES-GCM-SIV as specified in RFC 8452. The tag is computed
+// from the plaintext and then used as the initial counter, so sealing two
+// messages under one nonce reveals only whether they were identical.
+type gcmSIV struct {
+	block  cipher.Block
+	keyLen int
+}
+
+// newGCMSIV returns AES-GCM-SIV with a 16- or 32-byte key-generating key.
+func newGCMSIV(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 && len(key) != 32 {
+		return nil, aes.KeySizeError(len(key))
+	}
+	return &gcmSIV{block: block, keyLen: len(key)}, nil
+}
+
+func (g *gcmSIV) NonceSize() int { return gcmSIVNonceSize }
+
+func (g *gcmSIV) Overhead() int { return gcmSIVTagSize }
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != gcmSIVNonceSize {
+		panic("secure: incorrect nonce length given to AES-GCM-SIV")
+	}
+	authKey, enc := g.deriveKeys(nonce)
+	tag := g.tag(authKey, enc, nonce, plaintext, additionalData)
+	ret, out := sliceForAppend(dst, len(plaintext)+gcmSIVTagSize)
+	gcmSIVCTR(enc, tag, out[:len(plaintext)], plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != gcmSIVNonceSize {
+		panic("secure: incorrect nonce length given to AES-GCM-SIV")
+	}
+	if len(ciphertext) < gcmSIVTagSize {
+		return nil, errors.New("secure: message authentication failed")
+	}
+	var tag [16]byte
+	copy(tag[:], ciphertext[len(ciphertext)-gcmSIVTagSize:])
+	ciphertext = ciphertext[:len(ciphertext)-gcmSIVTagSize]
+	authKey, enc := g.deriveKeys(nonce)
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	gcmSIVCTR(enc, tag, out, ciphertext)
+	expected := g.tag(authKey, enc, nonce, out, additionalData)
+	if subtle.ConstantTimeCompare(expected[:], tag[:]) != 1 {
+		clear(out)
+		return nil, errors.New("secure: message authentication failed")
+	}
+	return ret, nil
+}
+
+// deriveKeys derives the per-nonce authentication and encryption keys.
+func (g *gcmSIV) deriveKeys(nonce []byte) ([16]byte, cipher.Block) {
+	var in, out [16]byte
+	copy(in[4:], nonce)
+	derived := make([]byte, 16+g.keyLen)
+	defer clear(derived)
+	for i := range len(derived) / 8 {
+		binary.LittleEndian.PutUint32(in[:4], uint32(i))
+		g.block.Encrypt(out[:], in[:])
+		copy(derived[i*8:], out[:8])
+	}
+	var authKey [16]byte
+	copy(authKey[:], derived[:16])
+	enc, err := aes.NewCipher(derived[16:])
+	if err != nil {
+		panic(err) // unreachable: the derived key has a valid length
+	}
+	return authKey, enc
+}
+
+func (g *gcmSIV) tag(authKey [16]byte, enc cipher.Block, nonce, plaintext, additionalData []byte) [16]byte {
+	p := newPolyval(authKey)
+	p.update(additionalData)
+	p.update(plaintext)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	p.update(lengths[:])
+	s := p.sum()
+	for i := range gcmSIVNonceSize {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	var tag [16]byte
+	enc.Encrypt(tag[:], s[:])
+	return tag
+}
+
+// gcmSIVCTR applies AES-CTR starting from the tag with its top bit set. Only
+// the first 32 bits of the counter block are incremented, little-endian.
+func gcmSIVCTR(enc cipher.Block, tag [16]byte, dst, src []byte) {
+	counter := tag
+	counter[15] |= 0x80
+	var keystream [16]byte
+	for len(src) > 0 {
+		enc.Encrypt(keystream[:], counter[:])
+		binary.LittleEndian.PutUint32(counter[:4], binary.LittleEndian.Uint32(counter[:4])+1)
+		n := subtle.XORBytes(dst, src, keystream[:])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// polyval computes POLYVAL from RFC 8452 over zero-padded 16-byte blocks.
+// Field elements are held as two little-endian 64-bit halves.
+type polyval struct {
+	h [2]uint64
+	s [2]uint64
+}
+
+func newPolyval(key [16]byte) *polyval {
+	return &polyval{h: [2]uint64{binary.LittleEndian.Uint64(key[:8]), binary.LittleEndian.Uint64(key[8:])}}
+}
+
+// update absorbs b padded with zeros to a whole number of blocks.
+func (p *polyval) update(b []byte) {
+	for len(b) > 0 {
+		var block [16]byte
+		n := copy(block[:], b)
+		b = b[n:]
+		p.s[0] ^= binary.LittleEndian.Uint64(block[:8])
+		p.s[1] ^= binary.LittleEndian.Uint64(block[8:])
+		p.s = polyvalDot(p.s, p.h)
+	}
+}
+
+func (p *polyval) sum() [16]byte {
+	var out [16]byte
+	binary.LittleEndian.PutUint64(out[:8], p.s[0])
+	binary.LittleEndian.PutUint64(out[8:], p.s[1])
+	return out
+}
+
+// polyvalDot returns a·b·x⁻¹²⁸ in GF(2¹²⁸) modulo
+// x¹²⁸ + x¹²⁷ + x¹²⁶ + x¹²¹ + 1, using Karatsuba multiplication followed by
+// Montgomery reduction.
+func polyvalDot(a, b [2]uint64) [2]uint64 {
+	lo0, lo1 := clmul64(a[0], b[0])
+	hi0, hi1 := clmul64(a[1], b[1])
+	mid0, mid1 := clmul64(a[0]^a[1], b[0]^b[1])
+	mid0 ^= lo0 ^ hi0
+	mid1 ^= lo1 ^ hi1
+	v0, v1, v2, v3 := lo0, lo1^mid0, hi0^mid1, hi1
+
+	// Adding v0·P clears the lowest word, since P ≡ 1 mod x⁶⁴; then the same
+	// for v1. What remains is the product divided by x¹²⁸.
+	v1 ^= v0<<63 ^ v0<<62 ^ v0<<57
+	v2 ^= v0 ^ v0>>1 ^ v0>>2 ^ v0>>7
+	v2 ^= v1<<63 ^ v1<<62 ^ v1<<57
+	v3 ^= v1 ^ v1>>1 ^ v1>>2 ^ v1>>7
+	return [2]uint64{v2, v3}
+}
+
+// clmul64 returns the 128-bit carry-less product of x and y.
+func clmul64(x, y uint64) (lo, hi uint64) {
+	x0, x1 := uint32(x), uint32(x>>32)
+	y0, y1 := uint32(y), uint32(y>>32)
+	l := clmul32(x0, y0)
+	h := clmul32(x1, y1)
+	m := clmul32(x0^x1, y0^y1) ^ l ^ h
+	return l ^ m<<32, h ^ m>>32
+}
+
+// clmul32 is a constant-time carry-less multiplication of two 32-bit values.
+// It uses ordinary multiplication on inputs masked to every fourth bit, so
+// carries fall into the three-bit holes between them and are masked away;
+// see https://www.bearssl.org/constanttime.html#ghash-for-gcm.
+func clmul32(x, y uint32) uint64 {
+	var xm, ym [4]uint64
+	for i := range 4 {
+		xm[i] = uint64(x & (0x11111111 << i))
+		ym[i] = uint64(y & (0x11111111 << i))
+	}
+	var z uint64
+	for i := range 4 {
+		zi := xm[0]*ym[i] ^ xm[1]*ym[(i+3)%4] ^ xm[2]*ym[(i+2)%4] ^ xm[3]*ym[(i+1)%4]
+		z |= zi & (0x1111111111111111 << i)
+	}
+	return z
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return head, tail
+}
@@ -0,0 +1,147 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPolyvalKnownAnswer(t *testing.T) {
+	// RFC 8452, Appendix A.
+	var h [16]byte
+	hex.Decode(h[:], []byte("25629347589242761d31f826ba4b757b"))
+	x, _ := hex.DecodeString("4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362")
+	p := newPolyval(h)
+	p.update(x)
+	if got := p.sum(); hex.EncodeToString(got[:]) != "f7a3b47b846119fae5b7866cf5e5b77e" {
+		t.Fatalf("POLYVAL = %x", got)
+	}
+}
+
+func TestGCMSIVKnownAnswers(t *testing.T) {
+	// RFC 8452, Appendix C: the first AES-128 vectors, every AES-256 vector
+	// of C.2, and the counter wrap vectors of C.3.
+	const (
+		key128 = "01000000000000000000000000000000"
+		key256 = "0100000000000000000000000000000000000000000000000000000000000000"
+		zero   = "0000000000000000000000000000000000000000000000000000000000000000"
+		nonce  = "030000000000000000000000"
+	)
+	for _, tc := range []struct {
+		key, nonce, aad, plaintext, want string
+	}{
+		{key128, nonce, "", "", "dc20e2d83f25705bb49e439eca56de25"},
+		{key128, nonce, "", "0100000000000000", "b5d839330ac7b786578782fff6013b815b287c22493a364c"},
+
+		{key256, nonce, "", "", "07f5f4169bbf55a8400cd47ea6fd400f"},
+		{key256, nonce, "", "0100000000000000", "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28"},
+		{key256, nonce, "", "010000000000000000000000", "9aab2aeb3faa0a34aea8e2b18ca50da9ae6559e48fd10f6e5c9ca17e"},
+		{key256, nonce, "", "01000000000000000000000000000000", "85a01b63025ba19b7fd3ddfc033b3e76c9eac6fa700942702e90862383c6c366"},
+		{key256, nonce, "", "0100000000000000000000000000000002000000000000000000000000000000", "4a6a9db4c8c6549201b9edb53006cba821ec9cf850948a7c86c68ac7539d027fe819e63abcd020b006a976397632eb5d"},
+		{key256, nonce, "", "010000000000000000000000000000000200000000000000000000000000000003000000000000000000000000000000", "c00d121893a9fa603f48ccc1ca3c57ce7499245ea0046db16c53c7c66fe717e39cf6c748837b61f6ee3adcee17534ed5790bc96880a99ba804bd12c0e6a22cc4"},
+		{key256, nonce, "", "01000000000000000000000000000000020000000000000000000000000000000300000000000000000000000000000004000000000000000000000000000000", "c2d5160a1f8683834910acdafc41fbb1632d4a353e8b905ec9a5499ac34f96c7e1049eb080883891a4db8caaa1f99dd004d80487540735234e3744512c6f90ce112864c269fc0d9d88c61fa47e39aa08"},
+		{key256, nonce, "01", "0200000000000000", "1de22967237a813291213f267e3b452f02d01ae33e4ec854"},
+		{key256, nonce, "01", "020000000000000000000000", "163d6f9cc1b346cd453a2e4cc1a4a19ae800941ccdc57cc8413c277f"},
+		{key256, nonce, "01", "02000000000000000000000000000000", "c91545823cc24f17dbb0e9e807d5ec17b292d28ff61189e8e49f3875ef91aff7"},
+		{key256, nonce, "01", "0200000000000000000000000000000003000000000000000000000000000000", "07dad364bfc2b9da89116d7bef6daaaf6f255510aa654f920ac81b94e8bad365aea1bad12702e1965604374aab96dbbc"},
+		{key256, nonce, "01", "020000000000000000000000000000000300000000000000000000000000000004000000000000000000000000000000", "c67a1f0f567a5198aa1fcc8e3f21314336f7f51ca8b1af61feac35a86416fa47fbca3b5f749cdf564527f2314f42fe2503332742b228c647173616cfd44c54eb"},
+		{key256, nonce, "01", "02000000000000000000000000000000030000000000000000000000000000000400000000000000000000000000000005000000000000000000000000000000", "67fd45e126bfb9a79930c43aad2d36967d3f0e4d217c1e551f59727870beefc98cb933a8fce9de887b1e40799988db1fc3f91880ed405b2dd298318858467c895bde0285037c5de81e5b570a049b62a0"},
+		{key256, nonce, "010000000000000000000000", "02000000", "22b3f4cd1835e517741dfddccfa07fa4661b74cf"},
+		{key256, nonce, "0100000000000000000000000000000002000000", "030000000000000000000000000000000400", "462401724b5ce6588d5a54aae5375513a075cfcdf5042112aa29685c912fc2056543"},
+		{key256, nonce, "010000000000000000000000000000000200", "0300000000000000000000000000000004000000", "43dd0163cdb48f9fe3212bf61b201976067f342bb879ad976d8242acc188ab59cabfe307"},
+
+		// The tags start the CTR counter at 0xffffffff, so it wraps to zero.
+		{zero, "000000000000000000000000", "", "000000000000000000000000000000004db923dc793ee6497c76dcc03a98e108", "f3f80f2cf0cb2dd9c5984fcda908456cc537703b5ba70324a6793a7bf218d3eaffffffff000000000000000000000000"},
+		{zero, "000000000000000000000000", "", "eb3640277c7ffd1303c7a542d02d3e4c0000000000000000", "18ce4f0b8cb4d0cac65fea8f79257b20888e53e72299e56dffffffff000000000000000000000000"},
+	} {
+		key, _ := hex.DecodeString(tc.key)
+		nonce, _ := hex.DecodeString(tc.nonce)
+		aad, _ := hex.DecodeString(tc.aad)
+		plaintext, _ := hex.DecodeString(tc.plaintext)
+		aead, err := newGCMSIV(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed := aead.Seal(nil, nonce, plaintext, aad)
+		if got := hex.EncodeToString(sealed); got != tc.want {
+			t.Fatalf("AES-%d Seal(%s, %s) = %s, want %s", len(key)*8, tc.plaintext, tc.aad, got, tc.want)
+		}
+		opened, err := aead.Open(nil, nonce, sealed, aad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("AES-%d Open(%s) = %x, %v", len(key)*8, tc.want, opened, err)
+		}
+	}
+}
+
+func TestGCMSIVRoundTrip(t *testing.T) {
+	aead, _ := newGCMSIV(testKey)
+	nonce := make([]byte, aead.NonceSize())
+	aad := []byte("header")
+	for _, n := range []int{1, 15, 16, 17, 1000} {
+		plaintext := bytes.Repeat([]byte{byte(n)}, n)
+		sealed := aead.Seal([]byte("dst"), nonce, plaintext, aad)
+		if string(sealed[:3]) != "dst" || len(sealed) != 3+n+aead.Overhead() {
+			t.Fatalf("Seal() did not append to dst")
+		}
+		opened, err := aead.Open(nil, nonce, sealed[3:], aad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Open(%d bytes) = %v", n, err)
+		}
+		for _, i := range []int{3, len(sealed) - 1} {
+			tampered := append([]byte(nil), sealed[3:]...)
+			tampered[i-3] ^= 1
+			if _, err := aead.Open(nil, nonce, tampered, aad); err == nil {
+				t.Fatalf("tampering at %d was accepted", i)
+			}
+		}
+		if _, err := aead.Open(nil, nonce, sealed[3:], nil); err == nil {
+			t.Fatal("wrong AAD was accepted")
+		}
+	}
+	if _, err := aead.Open(nil, nonce, make([]byte, aead.Overhead()-1), nil); err == nil {
+		t.Fatal("short ciphertext was accepted")
+	}
+	if _, err := newGCMSIV(make([]byte, 24)); err == nil {
+		t.Fatal("accepted 24-byte key")
+	}
+	if _, err := newAEAD(AES256GCMSIV, make([]byte, 16)); err == nil {
+		t.Fatal("accepted 16-byte key for AES-256-GCM-SIV")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("short nonce did not panic")
+		}
+	}()
+	aead.Seal(nil, nonce[:8], nil, nil)
+}
+
+// polyvalDotReference multiplies bit by bit: it adds a for each set bit of b,
+// lowest first, and multiplies by x⁻¹ after every step.
+func polyvalDotReference(a, b [2]uint64) [2]uint64 {
+	var r [2]uint64
+	for i := range 128 {
+		if b[i/64]>>(i%64)&1 == 1 {
+			r[0] ^= a[0]
+			r[1] ^= a[1]
+		}
+		// x⁻¹ = x¹²⁷ + x¹²⁶ + x¹²⁵ + x¹²⁰
+		carry := r[0] & 1
+		r[0] = r[0]>>1 | r[1]<<63
+		r[1] >>= 1
+		if carry == 1 {
+			r[1] ^= 0xe100000000000000
+		}
+	}
+	return r
+}
+
+func TestPolyvalDotMatchesReference(t *testing.T) {
+	x := [2]uint64{0x0123456789abcdef, 0xfedcba9876543210}
+	y := [2]uint64{^uint64(0), 1}
+	for range 1000 {
+		if got, want := polyvalDot(x, y), polyvalDotReference(x, y); got != want {
+			t.Fatalf("polyvalDot(%x, %x) = %x, want %x", x, y, got, want)
+		}
+		x, y = polyvalDotReference(x, y), [2]uint64{x[1] ^ y[0], x[0] + y[1]}
+	}
+}
@@ -0,0 +1,416 @@
+package secure
+
+import (
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	hybridCiphertextSize = x25519KeySize + mlkem.CiphertextSize768
+	hybridPublicKeySize  = x25519KeySize + mlkem.EncapsulationKeySize768
+	hybridPrivateKeySize = x25519KeySize + mlkem.SeedSize
+
+	x25519RecipientPrefix = "secx25519:"
+	hybridRecipientPrefix = "secpq:"
+)
+
+// PublicKeyRecipient is a public key that envelopes and streams can be sealed
+// to. *X25519Recipient and *HybridRecipient implement it, and either can be
+// passed to NewMultiRecipient.
+type PublicKeyRecipient interface {
+	Recipient
+	Seal(plaintext, additionalData []byte) (string, error)
+	EncryptString(plaintext string) (string, error)
+	NewEncryptWriter(w io.Writer, opts ...StreamOption) (io.WriteCloser, error)
+	String() string
+}
+
+// ParseRecipient decodes a recipient produced by the String method of an
+// X25519Recipient or HybridRecipient. The prefix selects the mode, so
+// services can migrate recipients to post-quantum keys through configuration
+// alone.
+func ParseRecipient(s string, opts ...Option) (PublicKeyRecipient, error) {
+	switch {
+	case strings.HasPrefix(s, x25519RecipientPrefix):
+		key, err := base64.RawURLEncoding.DecodeString(s[len(x25519RecipientPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid recipient encoding", ErrInvalidEnvelope)
+		}
+		return NewX25519Recipient(key, opts...)
+	case strings.HasPrefix(s, hybridRecipientPrefix):
+		key, err := base64.RawURLEncoding.DecodeString(s[len(hybridRecipientPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid recipient encoding", ErrInvalidEnvelope)
+		}
+		return NewHybridRecipient(key, opts...)
+	default:
+		return nil, fmt.Errorf("%w: unknown recipient type", ErrInvalidEnvelope)
+	}
+}
+
+// HybridRecipient seals envelopes and streams to a combined X25519 and
+// ML-KEM-768 public key. The content key depends on both shared secrets, so
+// the data stays confidential unless both X25519 and ML-KEM are broken. This
+// protects long-lived ciphertext against later decryption by a quantum
+// computer.
+type HybridRecipient struct {
+	x   *X25519Recipient
+	ek  *mlkem.EncapsulationKey768
+	cfg config
+}
+
+// NewHybridRecipient creates a recipient from a 1216-byte public key: the
+// X25519 public key followed by the ML-KEM-768 encapsulation key.
+func NewHybridRecipient(publicKey []byte, opts ...Option) (*HybridRecipient, error) {
+	if len(publicKey) != hybridPublicKeySize {
+		return nil, fmt.Errorf("secure: hybrid public key must be %d bytes", hybridPublicKeySize)
+	}
+	x, err := NewX25519Recipient(publicKey[:x25519KeySize], opts...)
+	if err != nil {
+		return nil, err
+	}
+	ek, err := mlkem.NewEncapsulationKey768(publicKey[x25519KeySize:])
+	if err != nil {
+		return nil, fmt.Errorf("secure: invalid ML-KEM-768 public key: %w", err)
+	}
+	return &HybridRecipient{x: x, ek: ek, cfg: x.cfg}, nil
+}
+
+func (r *HybridRecipient) validate() error {
+	if r == nil || r.ek == nil {
+		return ErrUnconfigured
+	}
+	return r.x.validate()
+}
+
+// Bytes returns the recipient's combined public key.
+func (r *HybridRecipient) Bytes() []byte {
+	if r.validate() != nil {
+		return nil
+	}
+	return append(r.x.Bytes(), r.ek.Bytes()...)
+}
+
+// String encodes the recipient for ParseRecipient.
+func (r *HybridRecipient) String() string {
+	if r.validate() != nil {
+		return ""
+	}
+	return hybridRecipientPrefix + base64.RawURLEncoding.EncodeToString(r.Bytes())
+}
+
+// Seal encrypts plaintext to the recipient.
+func (r *HybridRecipient) Seal(plaintext, additionalData []byte) (string, error) {
+	if err := r.validate(); err != nil {
+		return "", err
+	}
+	ciphertext, key, err := r.encapsulate()
+	if err != nil {
+		return "", err
+	}
+	defer clear(key)
+	return sealWithKey(key, modeHybrid, ciphertext, plaintext, additionalData, r.cfg)
+}
+
+// EncryptString encrypts a UTF-8 string without additional data.
+func (r *HybridRecipient) EncryptString(plaintext string) (string, error) {
+	return r.Seal([]byte(plaintext), nil)
+}
+
+// NewEncryptWriter returns an authenticated streaming writer sealed to the
+// recipient. Close must be called to write the authenticated final record.
+func (r *HybridRecipient) NewEncryptWriter(w io.Writer, opts ...StreamOption) (io.WriteCloser, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, errors.New("secure: nil writer")
+	}
+	ciphertext, shared, err := r.encapsulate()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(shared)
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r.cfg.rand, salt); err != nil {
+		return nil, err
+	}
+	x := r.cfg.streamExtensions(sc)
+	header := append(streamPrefix(modeHybrid, x), ciphertext...)
+	header = append(header, salt...)
+	key, err := deriveStreamKey(shared, salt)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, key, header, header, x, sc)
+}
+
+func (r *HybridRecipient) wrapContentKey(contentKey []byte) (stanza, error) {
+	if err := r.validate(); err != nil {
+		return stanza{}, err
+	}
+	ciphertext, kek, err := r.encapsulate()
+	if err != nil {
+		return stanza{}, err
+	}
+	defer clear(kek)
+	wrapped, err := wrapKeyGCM(kek, contentKey, stanzaHybrid, r.cfg.rand)
+	if err != nil {
+		return stanza{}, err
+	}
+	return stanza{stanzaHybrid, append(ciphertext, wrapped...)}, nil
+}
+
+// encapsulate returns the combined ciphertext, the ephemeral X25519 public key
+// followed by the ML-KEM-768 ciphertext, and the key it establishes.
+func (r *HybridRecipient) encapsulate() (ciphertext, key []byte, err error) {
+	priv, err := ecdh.X25519().GenerateKey(r.cfg.rand)
+	if err != nil {
+		return nil, nil, fmt.Errorf("secure: generate ephemeral key: %w", err)
+	}
+	ephemeral := priv.PublicKey().Bytes()
+	sharedX, err := priv.ECDH(r.x.pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(sharedX)
+	sharedM, ciphertextM := r.ek.Encapsulate()
+	defer clear(sharedM)
+	key, err = hybridKey(sharedM, sharedX, ephemeral, r.x.pub.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(ephemeral, ciphertextM...), key, nil
+}
+
+// HybridIdentity holds the private halves of a HybridRecipient. It opens
+// hybrid envelopes and streams, and also classic X25519 ones sealed to its
+// X25519 public key, so recipients can move to post-quantum keys without a
+// flag day.
+type HybridIdentity struct {
+	x   *X25519Identity
+	dk  *mlkem.DecapsulationKey768
+	cfg config
+}
+
+// GenerateHybridIdentity creates an identity with new random keys.
+func GenerateHybridIdentity(opts ...Option) (*HybridIdentity, error) {
+	x, err := GenerateX25519Identity(opts...)
+	if err != nil {
+		return nil, err
+	}
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, err
+	}
+	return &HybridIdentity{x: x, dk: dk, cfg: x.cfg}, nil
+}
+
+// NewHybridIdentity creates an identity from a 96-byte private key: the
+// X25519 private key followed by the 64-byte ML-KEM-768 seed.
+func NewHybridIdentity(privateKey []byte, opts ...Option) (*HybridIdentity, error) {
+	if len(privateKey) != hybridPrivateKeySize {
+		return nil, fmt.Errorf("secure: hybrid private key must be %d bytes", hybridPrivateKeySize)
+	}
+	x, err := NewX25519Identity(privateKey[:x25519KeySize], opts...)
+	if err != nil {
+		return nil, err
+	}
+	dk, err := mlkem.NewDecapsulationKey768(privateKey[x25519KeySize:])
+	if err != nil {
+		return nil, fmt.Errorf("secure: invalid ML-KEM-768 seed: %w", err)
+	}
+	return &HybridIdentity{x: x, dk: dk, cfg: x.cfg}, nil
+}
+
+func (i *HybridIdentity) validate() error {
+	if i == nil || i.dk == nil {
+		return ErrUnconfigured
+	}
+	return i.x.validate()
+}
+
+// Bytes returns the identity's private key. Handle it like any other secret.
+func (i *HybridIdentity) Bytes() []byte {
+	if i.validate() != nil {
+		return nil
+	}
+	return append(i.x.Bytes(), i.dk.Bytes()...)
+}
+
+// Recipient returns the public recipient for this identity.
+func (i *HybridIdentity) Recipient() *HybridRecipient {
+	if i.validate() != nil {
+		return nil
+	}
+	return &HybridRecipient{x: i.x.Recipient(), ek: i.dk.EncapsulationKey(), cfg: i.cfg}
+}
+
+// X25519Recipient returns the classic recipient for the identity's X25519
+// key. Envelopes sealed to it can be opened by the identity.
+func (i *HybridIdentity) X25519Recipient() *X25519Recipient {
+	if i.validate() != nil {
+		return nil
+	}
+	return i.x.Recipient()
+}
+
+// Seal encrypts plaintext to the identity's own hybrid public key.
+func (i *HybridIdentity) Seal(plaintext, additionalData []byte) (string, error) {
+	if err := i.validate(); err != nil {
+		return "", err
+	}
+	return i.Recipient().Seal(plaintext, additionalData)
+}
+
+// Open authenticates and decrypts a hybrid, X25519, or multi-recipient
+// envelope sealed to the identity.
+func (i *HybridIdentity) Open(envelope string, additionalData []byte) ([]byte, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+	if len(additionalData) > i.cfg.maxEnvelope {
+		return nil, ErrLimitExceeded
+	}
+	env, err := parseEnvelope(envelope, i.cfg.maxEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := i.open(env, additionalData)
+	return i.cfg.checkExpiry(env, plaintext, err)
+}
+
+func (i *HybridIdentity) open(env envelope, additionalData []byte) ([]byte, error) {
+	switch env.mode {
+	case modeHybrid:
+		key, err := i.decapsulate(env.fields)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(key)
+		return openAEAD(key, env, additionalData)
+	case modeX25519:
+		key, err := i.x.decapsulate(env.fields)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(key)
+		return openAEAD(key, env, additionalData)
+	case modeMulti:
+		return openMulti(i, env, additionalData)
+	default:
+		return nil, fmt.Errorf("%w: envelope is not sealed to a public key", ErrInvalidEnvelope)
+	}
+}
+
+// EncryptString encrypts a UTF-8 string to the identity without additional data.
+func (i *HybridIdentity) EncryptString(plaintext string) (string, error) {
+	return i.Seal([]byte(plaintext), nil)
+}
+
+// DecryptString decrypts a UTF-8 string without additional data.
+func (i *HybridIdentity) DecryptString(envelope string) (string, error) {
+	b, err := i.Open(envelope, nil)
+	return string(b), err
+}
+
+// NewDecryptReader reads and authenticates a hybrid, X25519, or
+// multi-recipient stream sealed to the identity.
+func (i *HybridIdentity) NewDecryptReader(r io.Reader, opts ...StreamOption) (io.Reader, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readStreamHeader(r, modeHybrid, modeX25519, modeMulti)
+	if err != nil {
+		return nil, err
+	}
+	var shared []byte
+	switch h.mode {
+	case modeMulti:
+		return newMultiReader(i, r, h, sc)
+	case modeX25519:
+		shared, err = i.x.decapsulate(h.ephemeral)
+	default:
+		shared, err = i.decapsulate(h.ephemeral)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer clear(shared)
+	key, err := deriveStreamKey(shared, h.salt)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, key, h, sc)
+}
+
+func (i *HybridIdentity) unwrapContentKey(stanzas []stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.kind != stanzaHybrid || len(s.body) < hybridCiphertextSize {
+			continue
+		}
+		kek, err := i.decapsulate(s.body[:hybridCiphertextSize])
+		if err != nil {
+			continue
+		}
+		contentKey, err := unwrapKeyGCM(kek, s.body[hybridCiphertextSize:], stanzaHybrid)
+		clear(kek)
+		if err == nil {
+			return contentKey, nil
+		}
+	}
+	return i.x.unwrapContentKey(stanzas)
+}
+
+func (i *HybridIdentity) decapsulate(ciphertext []byte) ([]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(ciphertext[:x25519KeySize])
+	if err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+	sharedX, err := i.x.priv.ECDH(pub)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	defer clear(sharedX)
+	sharedM, err := i.dk.Decapsulate(ciphertext[x25519KeySize:])
+	if err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+	defer clear(sharedM)
+	return hybridKey(sharedM, sharedX, ciphertext[:x25519KeySize], i.x.priv.PublicKey().Bytes())
+}
+
+// hybridKey combines the ML-KEM and X25519 shared secrets. Following X-Wing,
+// the X25519 ephemeral and recipient public keys are bound into the result;
+// the ML-KEM ciphertext is not needed because ML-KEM is itself IND-CCA2.
+func hybridKey(sharedM, sharedX, ephemeral, recipient []byte) ([]byte, error) {
+	secret := make([]byte, 0, len(sharedM)+len(sharedX))
+	secret = append(secret, sharedM...)
+	secret = append(secret, sharedX...)
+	defer clear(secret)
+	info := []byte("github.com/rusq/secure/v2 x25519-mlkem768")
+	info = append(info, ephemeral...)
+	info = append(info, recipient...)
+	r := hkdf.New(sha256.New, secret, nil, info)
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
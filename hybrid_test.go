@@ -0,0 +1,252 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// katHybridIdentity returns the identity used to produce
+// testdata/x25519-mlkem768.env: private key bytes 1 through 96.
+func katHybridIdentity(t *testing.T) *HybridIdentity {
+	t.Helper()
+	priv := make([]byte, hybridPrivateKeySize)
+	for i := range priv {
+		priv[i] = byte(i + 1)
+	}
+	id, err := NewHybridIdentity(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestHybridKnownAnswer(t *testing.T) {
+	id := katHybridIdentity(t)
+	pub := id.Recipient().Bytes()
+	if got := hex.EncodeToString(pub[:64]); got != "07a37cbc142093c8b755dc1b10e86cb426374ad16aa853ed0bdfc0b2b86d1c7ca5ca70630b4e4d850b2a08cb34d681e548953783350050219c195503a569dac2" {
+		t.Fatalf("public key prefix = %s", got)
+	}
+	if sum := sha256.Sum256(pub); hex.EncodeToString(sum[:]) != "738ca51d9b289139e606a674296c903dfb718f4960d1808c8a5670e16e0c4180" {
+		t.Fatalf("public key digest = %x", sum)
+	}
+
+	key, err := hybridKey(bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32), bytes.Repeat([]byte{3}, 32), bytes.Repeat([]byte{4}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(key); got != "010190c2da7cfaaf53408cbfebda425bce1c27e5f85e3e2b0afdb5a4966a4257" {
+		t.Fatalf("hybridKey() = %s", got)
+	}
+
+	envelope, err := os.ReadFile("testdata/x25519-mlkem768.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := id.Open(strings.TrimSpace(string(envelope)), []byte("kat"))
+	if err != nil || string(got) != "harvest now, decrypt never" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+}
+
+func TestHybridEnvelope(t *testing.T) {
+	backend, err := GenerateHybridIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingest, err := ParseRecipient(backend.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ingest.(*HybridRecipient); !ok {
+		t.Fatalf("ParseRecipient() = %T", ingest)
+	}
+	aad := []byte("archive/2031")
+	envelope, err := ingest.Seal([]byte("long-lived secret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.Open(envelope, aad); err != nil || string(got) != "long-lived secret" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	if _, err := backend.Open(envelope, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+	other, _ := GenerateHybridIdentity()
+	if _, err := other.Open(envelope, aad); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("other identity error = %v", err)
+	}
+
+	restored, err := NewHybridIdentity(backend.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := restored.EncryptString("to self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.DecryptString(self); err != nil || got != "to self" {
+		t.Fatalf("DecryptString() = %q, %v", got, err)
+	}
+	x, _ := NewX25519Identity(backend.Bytes()[:x25519KeySize])
+	if _, err := x.DecryptString(self); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("X25519 identity opened hybrid envelope: %v", err)
+	}
+}
+
+func TestHybridOpensClassicRecipients(t *testing.T) {
+	backend, _ := GenerateHybridIdentity()
+	classic, err := ParseRecipient(backend.X25519Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := classic.(*X25519Recipient); !ok {
+		t.Fatalf("ParseRecipient() = %T", classic)
+	}
+	envelope, err := classic.EncryptString("before migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.DecryptString(envelope); err != nil || got != "before migration" {
+		t.Fatalf("DecryptString() = %q, %v", got, err)
+	}
+
+	input := bytes.Repeat([]byte("log line\n"), streamChunkSize/4)
+	for name, recipient := range map[string]PublicKeyRecipient{"classic": classic, "hybrid": backend.Recipient()} {
+		var encrypted bytes.Buffer
+		w, err := recipient.NewEncryptWriter(&encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := backend.NewDecryptReader(bytes.NewReader(encrypted.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+			t.Fatalf("%s: ReadAll() = %d bytes, %v", name, len(got), err)
+		}
+	}
+}
+
+func TestHybridMultiRecipient(t *testing.T) {
+	pq, _ := GenerateHybridIdentity()
+	classic, _ := GenerateX25519Identity()
+	m, err := NewMultiRecipient([]Recipient{pq.Recipient(), classic.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := m.EncryptString("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, id := range map[string]interface {
+		DecryptString(string) (string, error)
+	}{"hybrid": pq, "x25519": classic} {
+		if got, err := id.DecryptString(envelope); err != nil || got != "shared" {
+			t.Fatalf("%s: DecryptString() = %q, %v", name, got, err)
+		}
+	}
+	other, _ := GenerateHybridIdentity()
+	if _, err := other.DecryptString(envelope); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("other identity error = %v", err)
+	}
+}
+
+func TestHybridRejectsHostileInput(t *testing.T) {
+	backend, _ := GenerateHybridIdentity()
+	envelope, _ := backend.EncryptString("x")
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+
+	lowOrder := append([]byte(nil), packed...)
+	copy(lowOrder[2:2+x25519KeySize], make([]byte, x25519KeySize))
+	if _, err := backend.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(lowOrder)); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("low-order point error = %v", err)
+	}
+	for _, offset := range []int{2, 2 + x25519KeySize, 2 + hybridCiphertextSize - 1, len(packed) - 1} {
+		mutated := append([]byte(nil), packed...)
+		mutated[offset] ^= 1
+		if _, err := backend.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(mutated)); err == nil {
+			t.Fatalf("tampering at %d was accepted", offset)
+		}
+	}
+	if _, err := backend.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(packed[:2+hybridCiphertextSize-1])); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("truncated ciphertext error = %v", err)
+	}
+	c, _ := NewCipher(testKey)
+	keyEnvelope, _ := c.EncryptString("key")
+	if _, err := backend.DecryptString(keyEnvelope); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("key envelope error = %v", err)
+	}
+
+	for _, key := range [][]byte{nil, make([]byte, hybridPublicKeySize-1)} {
+		if _, err := NewHybridRecipient(key); err == nil {
+			t.Fatalf("accepted %d-byte public key", len(key))
+		}
+		if _, err := NewHybridIdentity(key); err == nil {
+			t.Fatalf("accepted %d-byte private key", len(key))
+		}
+	}
+	badEK := backend.Recipient().Bytes()
+	for i := x25519KeySize; i < len(badEK)-32; i++ {
+		badEK[i] = 0xff
+	}
+	if _, err := NewHybridRecipient(badEK); err == nil {
+		t.Fatal("accepted unreduced ML-KEM public key")
+	}
+	for _, s := range []string{"", "secpq:!", "secx25519:!", "secpq:AAAA", "age1xyz"} {
+		if _, err := ParseRecipient(s); err == nil {
+			t.Fatalf("ParseRecipient(%q) succeeded", s)
+		}
+	}
+	if _, err := GenerateHybridIdentity(nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("nil option error = %v", err)
+	}
+	if _, err := backend.Open(prefix, make([]byte, defaultMaxEnvelope+1)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("large AAD error = %v", err)
+	}
+}
+
+func TestHybridUnconfigured(t *testing.T) {
+	var r *HybridRecipient
+	var i *HybridIdentity
+	if r.Bytes() != nil || r.String() != "" || i.Bytes() != nil || i.Recipient() != nil || i.X25519Recipient() != nil {
+		t.Fatal("nil keys returned data")
+	}
+	for name, call := range map[string]func() error{
+		"Recipient Seal":      func() error { _, err := r.Seal(nil, nil); return err },
+		"Recipient writer":    func() error { _, err := r.NewEncryptWriter(io.Discard); return err },
+		"Identity Seal":       func() error { _, err := i.Seal(nil, nil); return err },
+		"Identity Open":       func() error { _, err := i.Open("", nil); return err },
+		"Identity reader":     func() error { _, err := i.NewDecryptReader(bytes.NewReader(nil)); return err },
+		"zero Recipient Seal": func() error { _, err := new(HybridRecipient).Seal(nil, nil); return err },
+		"zero Identity Open":  func() error { _, err := new(HybridIdentity).Open("", nil); return err },
+		"Multi Seal": func() error {
+			m, err := NewMultiRecipient([]Recipient{r})
+			if err != nil {
+				return err
+			}
+			_, err = m.Seal(nil, nil)
+			return err
+		},
+	} {
+		if err := call(); !errors.Is(err, ErrUnconfigured) {
+			t.Fatalf("%s error = %v", name, err)
+		}
+	}
+	backend, _ := GenerateHybridIdentity()
+	if _, err := backend.Recipient().NewEncryptWriter(nil); err == nil {
+		t.Fatal("accepted nil writer")
+	}
+}
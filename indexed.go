@@ -0,0 +1,104 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// IndexedString is an encrypted JSON string that carries its blind index. It
+// marshals to an object holding the envelope and the base64url-encoded index
+// tokens:
+//
+//	{"ciphertext":"SEC2.…","index":["…"]}
+//
+// The tokens are recomputed on every marshal and ignored on unmarshal. A bare
+// envelope written by EncryptedString is also accepted, so a field can switch
+// to IndexedString without rewriting stored values first.
+type IndexedString struct {
+	codec          Codec
+	indexer        *BlindIndexer
+	value          string
+	allowPlaintext bool
+}
+
+type indexedStringJSON struct {
+	Ciphertext string   `json:"ciphertext"`
+	Index      []string `json:"index"`
+}
+
+// NewIndexedString creates a configured encrypted JSON string indexed by
+// indexer.
+func NewIndexedString(codec Codec, indexer *BlindIndexer, value string, opts ...JSONOption) (IndexedString, error) {
+	if codec == nil || indexer == nil {
+		return IndexedString{}, ErrUnconfigured
+	}
+	cfg, err := applyJSONOptions(opts)
+	if err != nil {
+		return IndexedString{}, err
+	}
+	return IndexedString{codec: codec, indexer: indexer, value: value, allowPlaintext: cfg.allowPlaintext}, nil
+}
+
+// Value returns the decrypted value.
+func (s IndexedString) Value() string { return s.value }
+
+// Set replaces the plaintext value.
+func (s *IndexedString) Set(value string) { s.value = value }
+
+func (s IndexedString) String() string { return s.value }
+
+func (s IndexedString) MarshalJSON() ([]byte, error) {
+	if s.codec == nil || s.indexer == nil {
+		return nil, ErrUnconfigured
+	}
+	tokens, err := s.indexer.Index(s.value)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := s.codec.Seal([]byte(s.value), nil)
+	if err != nil {
+		return nil, err
+	}
+	out := indexedStringJSON{Ciphertext: envelope, Index: make([]string, len(tokens))}
+	for i, token := range tokens {
+		out.Index[i] = base64.RawURLEncoding.EncodeToString(token)
+	}
+	return json.Marshal(out)
+}
+
+func (s *IndexedString) UnmarshalJSON(data []byte) error {
+	if s == nil || s.codec == nil || s.indexer == nil {
+		return ErrUnconfigured
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return ErrInvalidEnvelope
+	}
+	var encoded string
+	if len(data) > 0 && data[0] == '{' {
+		var in indexedStringJSON
+		if err := json.Unmarshal(data, &in); err != nil {
+			return err
+		}
+		encoded = in.Ciphertext
+		if len(encoded) < len(prefix) || encoded[:len(prefix)] != prefix {
+			return ErrInvalidEnvelope
+		}
+	} else if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	if len(encoded) < len(prefix) || encoded[:len(prefix)] != prefix {
+		if !s.allowPlaintext {
+			return ErrInvalidEnvelope
+		}
+		s.value = encoded
+		return nil
+	}
+	plaintext, err := s.codec.Open(encoded, nil)
+	if err != nil {
+		return err
+	}
+	s.value = string(plaintext)
+	return nil
+}
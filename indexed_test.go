@@ -0,0 +1,105 @@
+package secure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIndexedStringJSON(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	b, _ := NewBlindIndexer(c, "users.email", WithCaseNormalization())
+	email, err := NewIndexedString(c, b, "Alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Ciphertext string   `json:"ciphertext"`
+		Index      []string `json:"index"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	query, _ := b.Query("alice@EXAMPLE.com")
+	if !strings.HasPrefix(raw.Ciphertext, prefix) || len(raw.Index) != 1 || raw.Index[0] != base64.RawURLEncoding.EncodeToString(query[0]) {
+		t.Fatalf("unexpected JSON %s", data)
+	}
+
+	decoded, _ := NewIndexedString(c, b, "")
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Value() != "Alice@example.com" {
+		t.Fatalf("got %q", decoded.Value())
+	}
+	decoded.Set("changed")
+	if decoded.String() != "changed" {
+		t.Fatalf("Set failed: %q", decoded.String())
+	}
+
+	// Values written by EncryptedString are read unchanged.
+	plain, _ := NewEncryptedString(c, "bob@example.com")
+	legacy, _ := json.Marshal(plain)
+	if err := json.Unmarshal(legacy, &decoded); err != nil || decoded.Value() != "bob@example.com" {
+		t.Fatalf("Unmarshal(EncryptedString) = %q, %v", decoded.Value(), err)
+	}
+}
+
+func TestIndexedStringRejectsInvalidInput(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	b, _ := NewBlindIndexer(c, "users.email")
+	strict, _ := NewIndexedString(c, b, "")
+	for _, input := range []string{`"plain"`, `null`, `{"ciphertext":"plain","index":[]}`, `{}`} {
+		if err := json.Unmarshal([]byte(input), &strict); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("Unmarshal(%s) error = %v", input, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"ciphertext":1}`), &strict); err == nil {
+		t.Fatal("accepted a malformed object")
+	}
+	if err := json.Unmarshal([]byte(`1`), &strict); err == nil {
+		t.Fatal("accepted a number")
+	}
+	if err := json.Unmarshal([]byte(`{"ciphertext":"SEC2.AAAA"}`), &strict); err == nil {
+		t.Fatal("accepted a corrupt envelope")
+	}
+
+	migrating, _ := NewIndexedString(c, b, "", WithPlaintextJSONMigration())
+	if err := json.Unmarshal([]byte(`"plain"`), &migrating); err != nil || migrating.Value() != "plain" {
+		t.Fatalf("migration = %q, %v", migrating.Value(), err)
+	}
+
+	ngram, _ := NewBlindIndexer(c, "x", WithNGramIndex(2))
+	long, _ := NewIndexedString(c, ngram, strings.Repeat("x", maxIndexedRunes+1))
+	if _, err := json.Marshal(long); err == nil {
+		t.Fatal("marshaled a value over the index limit")
+	}
+}
+
+func TestIndexedStringUnconfigured(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	if _, err := NewIndexedString(c, nil, "x"); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil indexer error = %v", err)
+	}
+	b, _ := NewBlindIndexer(c, "x")
+	if _, err := NewIndexedString(c, b, "x", nil); err == nil {
+		t.Fatal("accepted nil JSON option")
+	}
+	var zero IndexedString
+	if _, err := zero.MarshalJSON(); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("Marshal error = %v", err)
+	}
+	if err := zero.UnmarshalJSON([]byte(`"x"`)); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("Unmarshal error = %v", err)
+	}
+	failing, _ := NewIndexedString(&Cipher{}, b, "x")
+	if _, err := failing.MarshalJSON(); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("Marshal with unconfigured codec error = %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package secure
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Format identifies the kind of value reported by Inspect.
+type Format byte
+
+const (
+	// FormatEnvelope is a SEC2 envelope.
+	FormatEnvelope Format = 1
+	// FormatStream is the header of a SECS2 stream.
+	FormatStream Format = 2
+	// FormatLegacy is a v0.0.4 SEC. envelope, which only OpenLegacy reads.
+	FormatLegacy Format = 3
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatEnvelope:
+		return "SEC2"
+	case FormatStream:
+		return "SECS2"
+	case FormatLegacy:
+		return "SEC"
+	default:
+		return fmt.Sprintf("Format(%d)", byte(f))
+	}
+}
+
+// Mode identifies how the key of an envelope or stream is obtained.
+type Mode byte
+
+const (
+	// ModeKey is sealed directly with a Cipher's key.
+	ModeKey Mode = modeKey
+	// ModePassword is sealed with a key derived from a password by Argon2id.
+	ModePassword Mode = modePassword
+	// ModeKeyID is sealed with a Cipher's key and records its key ID.
+	ModeKeyID Mode = modeKeyID
+	// ModeDataKey is sealed with a random data key wrapped in the header.
+	ModeDataKey Mode = modeDataKey
+	// ModeX25519 is sealed to an X25519Recipient.
+	ModeX25519 Mode = modeX25519
+	// ModeMulti is sealed to several recipients by a MultiRecipient.
+	ModeMulti Mode = modeMulti
+	// ModeHybrid is sealed to a HybridRecipient.
+	ModeHybrid Mode = modeHybrid
+	// ModeDeterministic is sealed by a DeterministicCipher.
+	ModeDeterministic Mode = modeDeterministic
+	// ModePasswordSession is sealed by a PasswordSession.
+	ModePasswordSession Mode = modePasswordSession
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeKey:
+		return "key"
+	case ModePassword:
+		return "password"
+	case ModeKeyID:
+		return "key ID"
+	case ModeDataKey:
+		return "data key"
+	case ModeX25519:
+		return "X25519"
+	case ModeMulti:
+		return "multiple recipients"
+	case ModeHybrid:
+		return "X25519+ML-KEM-768"
+	case ModeDeterministic:
+		return "deterministic"
+	case ModePasswordSession:
+		return "password session"
+	default:
+		return fmt.Sprintf("Mode(%d)", byte(m))
+	}
+}
+
+// EnvelopeInfo describes an envelope or stream header as reported by
+// Inspect. Fields that do not apply to the value are left zero. Nothing in
+// it has been authenticated.
+type EnvelopeInfo struct {
+	Format  Format
+	Version int
+	// Mode is zero for legacy envelopes.
+	Mode      Mode
+	Algorithm Algorithm
+	// Argon2 holds the parameters of password envelopes and streams.
+	Argon2 Argon2Parameters
+	// SaltLen is the length of the salt stored in the header, including
+	// the per-value salt of ModePasswordSession envelopes.
+	SaltLen int
+	// Nonce is empty for streams, whose nonces are derived from a counter,
+	// and for deterministic envelopes.
+	Nonce []byte
+	// CiphertextLen includes the authentication tag. It is zero for
+	// streams.
+	CiphertextLen int
+	KeyID         []byte
+	// Recipients is the number of recipients of a ModeMulti value.
+	Recipients int
+	// RecordSize is the number of plaintext bytes per record of a stream.
+	RecordSize int
+	// MetadataRecord and TrailerRecord report whether a stream holds the
+	// encrypted records written by WithStreamMetadata and WithStreamTrailer.
+	MetadataRecord, TrailerRecord bool
+	// Committed reports whether the envelope carries a key commitment.
+	Committed bool
+	// Derivation holds the contexts passed to Cipher.Derive, outermost
+	// first.
+	Derivation [][]byte
+	// IssuedAt and NotAfter are zero unless the envelope records them.
+	IssuedAt, NotAfter time.Time
+	Metadata           map[string]string
+}
+
+// Inspect parses a SEC2 envelope without a key and describes its header. It
+// also recognizes v0.0.4 SEC. envelopes with the default armor, and SECS2
+// stream headers passed as the leading bytes of a stream, for example
+// string(buf[:n]). Inspect does not authenticate anything, so the result
+// must not be trusted for access decisions. SEC2 envelopes over the default
+// 16 MiB size limit are rejected with ErrLimitExceeded, even if they were
+// sealed under a larger WithMaxEnvelopeSize.
+func Inspect(envelope string) (EnvelopeInfo, error) {
+	switch {
+	case strings.HasPrefix(envelope, prefix):
+		return inspectEnvelope(envelope)
+	case strings.HasPrefix(envelope, streamMagic):
+		return inspectStream(envelope)
+	case strings.HasPrefix(envelope, defaultLegacyConfig().prefix):
+		return inspectLegacy(envelope)
+	default:
+		return EnvelopeInfo{}, ErrInvalidEnvelope
+	}
+}
+
+func inspectEnvelope(s string) (EnvelopeInfo, error) {
+	env, err := parseEnvelope(s, defaultMaxEnvelope)
+	if err != nil {
+		return EnvelopeInfo{}, err
+	}
+	info := extensionInfo(FormatEnvelope, env.mode, env.ext)
+	info.Nonce = append([]byte(nil), env.nonce...)
+	info.CiphertextLen = len(env.ciphertext)
+	info.Metadata = envelopeMetadata(env)
+	switch env.mode {
+	case modePassword, modePasswordSession:
+		info.Argon2, _ = parsePasswordParams(env.fields)
+		info.SaltLen = len(env.fields) - passwordParamsSize + saltSize
+	case modeKeyID:
+		info.KeyID = append([]byte(nil), env.fields[1:]...)
+	case modeDataKey:
+		id, _, _, _ := parseDataKeyHeader(env.fields)
+		info.KeyID = append([]byte(nil), id...)
+	case modeMulti:
+		stanzas, _, _ := parseStanzas(env.fields)
+		info.Recipients = len(stanzas)
+	}
+	return info, nil
+}
+
+func inspectStream(s string) (EnvelopeInfo, error) {
+	h, err := readStreamHeader(strings.NewReader(s), modeKey, modePassword, modeDataKey, modeX25519, modeMulti, modeHybrid)
+	if err != nil {
+		return EnvelopeInfo{}, err
+	}
+	info := extensionInfo(FormatStream, h.mode, h.ext)
+	info.SaltLen = len(h.salt)
+	info.KeyID = h.keyID
+	info.Recipients = len(h.stanzas)
+	info.RecordSize = h.ext.chunkSize()
+	info.MetadataRecord = h.ext.records&streamMetadata != 0
+	info.TrailerRecord = h.ext.records&streamTrailer != 0
+	if h.mode == modePassword {
+		info.Argon2 = h.argon
+	}
+	return info, nil
+}
+
+func extensionInfo(format Format, mode byte, x extensions) EnvelopeInfo {
+	info := EnvelopeInfo{Format: format, Version: envelopeVersion, Mode: Mode(mode), Algorithm: x.alg, Committed: x.committed}
+	for path := x.derivation; path != ""; {
+		n := int(path[0])
+		info.Derivation = append(info.Derivation, []byte(path[1:1+n]))
+		path = path[1+n:]
+	}
+	if x.hasIssuedAt {
+		info.IssuedAt = time.Unix(x.issuedAt, 0)
+	}
+	if x.hasNotAfter {
+		info.NotAfter = time.Unix(x.notAfter, 0)
+	}
+	return info
+}
+
+// inspectLegacy mirrors the layout read by openLegacy: an additional data
+// length byte, the additional data, a 12-byte nonce, and the ciphertext.
+func inspectLegacy(s string) (EnvelopeInfo, error) {
+	cfg := defaultLegacyConfig()
+	encoded := s[len(cfg.prefix):]
+	if cfg.encoding.DecodedLen(len(encoded)) > cfg.maxSize {
+		return EnvelopeInfo{}, ErrLimitExceeded
+	}
+	packed, err := cfg.encoding.DecodeString(encoded)
+	if err != nil || len(packed) < 1 {
+		return EnvelopeInfo{}, ErrInvalidEnvelope
+	}
+	const nonceSize = 12
+	nonceStart := 1 + int(packed[0])
+	if nonceStart > len(packed)-nonceSize-tagSize {
+		return EnvelopeInfo{}, ErrInvalidEnvelope
+	}
+	return EnvelopeInfo{
+		Format:        FormatLegacy,
+		Version:       1,
+		Algorithm:     AES256GCM,
+		Nonce:         append([]byte(nil), packed[nonceStart:nonceStart+nonceSize]...),
+		CiphertextLen: len(packed) - nonceStart - nonceSize,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	master, _ := NewCipher(testKey, WithKeyID([]byte("k1")), WithAlgorithm(XChaCha20Poly1305), WithKeyCommitment(),
+		WithIssuedAt(), WithExpiry(time.Hour), WithClock(clock.now))
+	tenant, _ := master.Derive([]byte("tenant-1"))
+	envelope, _ := tenant.SealWithMetadata([]byte("payload"), nil, map[string]string{"type": "invoice"})
+	info, err := Inspect(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != FormatEnvelope || info.Version != 2 || info.Mode != ModeKeyID || info.Algorithm != XChaCha20Poly1305 ||
+		string(info.KeyID) != "k1" || !info.Committed || len(info.Nonce) != 24 || info.CiphertextLen != len("payload")+tagSize ||
+		len(info.Derivation) != 1 || string(info.Derivation[0]) != "tenant-1" ||
+		!info.IssuedAt.Equal(clock.t) || !info.NotAfter.Equal(clock.t.Add(time.Hour)) ||
+		!maps.Equal(info.Metadata, map[string]string{"type": "invoice"}) || info.SaltLen != 0 {
+		t.Fatalf("Inspect() = %+v", info)
+	}
+	if info.Mode.String() != "key ID" || info.Format.String() != "SEC2" {
+		t.Fatalf("String() = %q, %q", info.Mode, info.Format)
+	}
+
+	p, _ := NewPasswordCipher([]byte("password"))
+	envelope, _ = p.EncryptString("secret")
+	if info, err := Inspect(envelope); err != nil || info.Mode != ModePassword || info.Argon2 != defaultArgon2Parameters() ||
+		info.SaltLen != saltSize || !info.Committed || len(info.Nonce) != 12 {
+		t.Fatalf("Inspect(password) = %+v, %v", info, err)
+	}
+
+	dataKeys, _ := NewCipher(testKey, WithKeyID([]byte("kek-1")), WithDataKeys())
+	envelope, _ = dataKeys.EncryptString("secret")
+	if info, err := Inspect(envelope); err != nil || info.Mode != ModeDataKey || string(info.KeyID) != "kek-1" || info.Committed {
+		t.Fatalf("Inspect(data key) = %+v, %v", info, err)
+	}
+
+	identity, _ := GenerateX25519Identity()
+	plain, _ := NewCipher(testKey)
+	m, _ := NewMultiRecipient([]Recipient{plain, identity.Recipient()})
+	envelope, _ = m.Seal([]byte("secret"), nil)
+	if info, err := Inspect(envelope); err != nil || info.Mode != ModeMulti || info.Recipients != 2 || info.Mode.String() != "multiple recipients" {
+		t.Fatalf("Inspect(multi) = %+v, %v", info, err)
+	}
+
+	d, _ := NewDeterministicCipher(testKey)
+	envelope, _ = d.EncryptString("secret")
+	if info, err := Inspect(envelope); err != nil || info.Mode != ModeDeterministic || len(info.Nonce) != 0 || info.CiphertextLen != len("secret")+16 {
+		t.Fatalf("Inspect(deterministic) = %+v, %v", info, err)
+	}
+
+	for _, invalid := range []string{"", "plain", "SEC2.!", "SEC2.AQE", "SECS2", "SEC.!", "SEC.AAAA"} {
+		if _, err := Inspect(invalid); err == nil {
+			t.Fatalf("Inspect(%q) succeeded", invalid)
+		}
+	}
+	if _, err := Inspect("SEC2.AwE"); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Inspect(v3) error = %v", err)
+	}
+}
+
+func TestInspectStreamAndLegacy(t *testing.T) {
+	var buf bytes.Buffer
+	c, _ := NewCipher(testKey, WithAlgorithm(AES256GCMSIV))
+	tenant, _ := c.Derive([]byte("tenant-1"))
+	w, _ := tenant.NewEncryptWriter(&buf)
+	w.Write(bytes.Repeat([]byte{1}, 100))
+	w.Close()
+	info, err := Inspect(buf.String()[:64])
+	if err != nil || info.Format != FormatStream || info.Mode != ModeKey || info.Algorithm != AES256GCMSIV ||
+		info.SaltLen != saltSize || len(info.Derivation) != 1 || info.Nonce != nil || info.CiphertextLen != 0 {
+		t.Fatalf("Inspect(stream) = %+v, %v", info, err)
+	}
+	if _, err := Inspect(buf.String()[:10]); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Inspect(truncated stream) error = %v", err)
+	}
+
+	info, err = Inspect("SEC.AIIPjL0a2HgLgOySAw9fAT6ovih9MfzkMv_pyWmmkA3eBxYbDlLQ")
+	if err != nil || info.Format != FormatLegacy || info.Version != 1 || info.Mode != 0 || len(info.Nonce) != 12 ||
+		info.CiphertextLen != len("plain text")+tagSize || info.Format.String() != "SEC" {
+		t.Fatalf("Inspect(legacy) = %+v, %v", info, err)
+	}
+}
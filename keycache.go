@@ -0,0 +1,114 @@
+package secure
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// maxKeyCacheEntries bounds WithPasswordKeyCache; at 32 bytes per key the
+// cache stays small, but every entry is a key kept in memory.
+const maxKeyCacheEntries = 4096
+
+// WithPasswordKeyCache keeps up to entries Argon2id-derived keys in memory,
+// indexed by salt and parameters, so that opening the same envelope or
+// stream again, or any envelope of a PasswordSession, skips the key
+// derivation. Only opening fills the cache. Evicted keys are zeroed, and
+// ClearKeyCache zeroes all of them.
+//
+// The cache trades the cost of Argon2id for keys that stay in process
+// memory: anyone who can read that memory can decrypt every cached envelope
+// without the password.
+func WithPasswordKeyCache(entries int) PasswordOption {
+	return func(c *passwordConfig) error {
+		if entries < 1 || entries > maxKeyCacheEntries {
+			return fmt.Errorf("%w: key cache must hold 1 to %d entries", ErrLimitExceeded, maxKeyCacheEntries)
+		}
+		c.cacheEntries = entries
+		return nil
+	}
+}
+
+// ClearKeyCache zeroes and drops every key cached by WithPasswordKeyCache.
+func (p *PasswordCipher) ClearKeyCache() {
+	if p != nil && p.cache != nil {
+		p.cache.clear()
+	}
+}
+
+// deriveKey returns the Argon2id key for salt and params, from the cache if
+// there is one. The caller owns the returned slice and should clear it.
+func (p *PasswordCipher) deriveKey(params Argon2Parameters, salt []byte) []byte {
+	if p.cache == nil {
+		return argon2.IDKey(p.passphrase, salt, params.Time, params.Memory, params.Threads, keySize)
+	}
+	id := keyCacheID{params: params, salt: [saltSize]byte(salt)}
+	if key, ok := p.cache.get(id); ok {
+		return key[:]
+	}
+	key := argon2.IDKey(p.passphrase, salt, params.Time, params.Memory, params.Threads, keySize)
+	p.cache.put(id, [keySize]byte(key))
+	return key
+}
+
+type keyCacheID struct {
+	params Argon2Parameters
+	salt   [saltSize]byte
+}
+
+type keyCacheEntry struct {
+	id  keyCacheID
+	key [keySize]byte
+}
+
+// keyCache is a least recently used cache of derived keys.
+type keyCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[keyCacheID]*list.Element
+	order   list.List // of *keyCacheEntry, most recently used first
+}
+
+func newKeyCache(size int) *keyCache {
+	return &keyCache{size: size, entries: make(map[keyCacheID]*list.Element)}
+}
+
+func (c *keyCache) get(id keyCacheID) ([keySize]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return [keySize]byte{}, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*keyCacheEntry).key, true
+}
+
+func (c *keyCache) put(id keyCacheID, key [keySize]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[id]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[id] = c.order.PushFront(&keyCacheEntry{id: id, key: key})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *keyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *keyCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*keyCacheEntry)
+	delete(c.entries, entry.id)
+	clear(entry.key[:])
+}
@@ -0,0 +1,87 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPasswordKeyCache(t *testing.T) {
+	p, err := NewPasswordCipher([]byte("password"), WithPasswordKeyCache(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelopes []string
+	for _, value := range []string{"a", "b", "c"} {
+		envelope, _ := p.EncryptString(value)
+		envelopes = append(envelopes, envelope)
+	}
+	if p.cache.order.Len() != 0 {
+		t.Fatal("sealing filled the key cache")
+	}
+	for range 2 {
+		if got, err := p.DecryptString(envelopes[0]); err != nil || got != "a" {
+			t.Fatalf("DecryptString() = %q, %v", got, err)
+		}
+	}
+	if p.cache.order.Len() != 1 {
+		t.Fatalf("cache holds %d keys, want 1", p.cache.order.Len())
+	}
+	var buf bytes.Buffer
+	w, _ := p.NewEncryptWriter(&buf)
+	w.Write([]byte("stream"))
+	w.Close()
+	r, _ := p.NewDecryptReader(&buf)
+	if got, err := io.ReadAll(r); err != nil || string(got) != "stream" {
+		t.Fatalf("stream = %q, %v", got, err)
+	}
+	p.DecryptString(envelopes[1])
+	if p.cache.order.Len() != 2 {
+		t.Fatalf("cache holds %d keys, want 2", p.cache.order.Len())
+	}
+
+	wrong, _ := NewPasswordCipher([]byte("wrong"), WithPasswordKeyCache(2))
+	if _, err := wrong.DecryptString(envelopes[0]); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong password error = %v", err)
+	}
+	p.ClearKeyCache()
+	if p.cache.order.Len() != 0 || len(p.cache.entries) != 0 {
+		t.Fatal("ClearKeyCache left keys")
+	}
+	if got, err := p.DecryptString(envelopes[2]); err != nil || got != "c" {
+		t.Fatalf("DecryptString() after clear = %q, %v", got, err)
+	}
+
+	var unconfigured *PasswordCipher
+	unconfigured.ClearKeyCache()
+	uncached, _ := NewPasswordCipher([]byte("password"))
+	uncached.ClearKeyCache()
+	for _, n := range []int{0, maxKeyCacheEntries + 1} {
+		if _, err := NewPasswordCipher([]byte("password"), WithPasswordKeyCache(n)); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("WithPasswordKeyCache(%d) error = %v", n, err)
+		}
+	}
+}
+
+func TestKeyCacheEviction(t *testing.T) {
+	c := newKeyCache(2)
+	ids := make([]keyCacheID, 3)
+	for i := range ids {
+		ids[i].salt[0] = byte(i)
+		c.put(ids[i], [keySize]byte{byte(i + 1)})
+	}
+	if _, ok := c.get(ids[0]); ok {
+		t.Fatal("least recently used key was not evicted")
+	}
+	c.get(ids[1])
+	oldest := c.order.Back().Value.(*keyCacheEntry)
+	c.put(ids[0], [keySize]byte{1})
+	c.put(ids[0], [keySize]byte{1})
+	if _, ok := c.get(ids[2]); ok || oldest.key != [keySize]byte{} {
+		t.Fatal("evicted key was not zeroed")
+	}
+	if key, ok := c.get(ids[1]); !ok || key[0] != 2 {
+		t.Fatalf("get() = %v, %v", key[0], ok)
+	}
+}
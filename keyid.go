@@ -0,0 +1,73 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	maxKeyIDSize     = 64
+	derivedKeyIDSize = 8
+)
+
+// WithKeyID assigns a key identifier to a Cipher and records it in every
+// envelope it seals. id must contain between 1 and 64 bytes and is stored in
+// the clear.
+func WithKeyID(id []byte) Option {
+	return func(c *config) error {
+		if len(id) == 0 || len(id) > maxKeyIDSize {
+			return fmt.Errorf("%w: key ID must be between 1 and %d bytes", ErrLimitExceeded, maxKeyIDSize)
+		}
+		c.keyID = append([]byte(nil), id...)
+		c.embedKeyID = true
+		return nil
+	}
+}
+
+// WithDerivedKeyID records the key identifier derived from the key in every
+// envelope a Cipher seals.
+func WithDerivedKeyID() Option {
+	return func(c *config) error {
+		c.embedKeyID = true
+		return nil
+	}
+}
+
+// KeyID returns the identifier assigned with WithKeyID or, if none was
+// assigned, an 8-byte identifier derived from the key.
+func (c *Cipher) KeyID() []byte {
+	if c == nil {
+		return nil
+	}
+	return append([]byte(nil), c.keyID...)
+}
+
+// EnvelopeKeyID returns the key identifier recorded in a SEC2 envelope without
+// decrypting it. It returns nil if the envelope does not carry a key ID. The
+// identifier is not authenticated until the envelope is opened. Envelopes
+// over the default 16 MiB size limit are rejected with ErrLimitExceeded,
+// even if they were sealed under a larger WithMaxEnvelopeSize.
+func EnvelopeKeyID(envelope string) ([]byte, error) {
+	env, err := parseEnvelope(envelope, defaultMaxEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	if env.mode != modeKeyID && env.mode != modeDataKey {
+		return nil, nil
+	}
+	return append([]byte(nil), env.fields[1:1+int(env.fields[0])]...), nil
+}
+
+func deriveKeyID(key []byte) ([]byte, error) {
+	r := hkdf.New(sha256.New, key, nil, []byte("github.com/rusq/secure/v2 key id"))
+	id := make([]byte, derivedKeyIDSize)
+	_, err := io.ReadFull(r, id)
+	return id, err
+}
+
+func keyIDHeader(id []byte) []byte {
+	return append([]byte{byte(len(id))}, id...)
+}
@@ -0,0 +1,100 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyIDEnvelopes(t *testing.T) {
+	c, err := NewCipher(testKey, WithKeyID([]byte("primary-2026")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := c.Seal([]byte("routed"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := EnvelopeKeyID(envelope)
+	if err != nil || string(id) != "primary-2026" {
+		t.Fatalf("EnvelopeKeyID() = %q, %v", id, err)
+	}
+	if got, err := c.Open(envelope, []byte("aad")); err != nil || string(got) != "routed" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+
+	other, _ := NewCipher(bytes.Repeat([]byte{0x99}, keySize), WithKeyID([]byte("other")))
+	if _, err := other.Open(envelope, []byte("aad")); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("wrong key error = %v", err)
+	}
+	sameKey, _ := NewCipher(testKey, WithKeyID([]byte("renamed")))
+	if _, err := sameKey.Open(envelope, []byte("aad")); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("renamed key error = %v", err)
+	}
+
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	packed[3] ^= 1
+	renamed := prefix + base64.RawURLEncoding.EncodeToString(packed)
+	forged, _ := NewCipher(testKey, WithKeyID(packed[3:3+len("primary-2026")]))
+	if _, err := forged.Open(renamed, []byte("aad")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("modified key ID error = %v", err)
+	}
+}
+
+func TestDerivedKeyID(t *testing.T) {
+	plain, _ := NewCipher(testKey)
+	derived, err := NewCipher(testKey, WithDerivedKeyID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(derived.KeyID()) != derivedKeyIDSize || !bytes.Equal(plain.KeyID(), derived.KeyID()) {
+		t.Fatalf("derived key IDs differ: %x, %x", plain.KeyID(), derived.KeyID())
+	}
+	other, _ := NewCipher(bytes.Repeat([]byte{0x99}, keySize))
+	if bytes.Equal(plain.KeyID(), other.KeyID()) {
+		t.Fatal("different keys share a derived ID")
+	}
+
+	withID, _ := derived.EncryptString("derived")
+	id, err := EnvelopeKeyID(withID)
+	if err != nil || !bytes.Equal(id, derived.KeyID()) {
+		t.Fatalf("EnvelopeKeyID() = %x, %v", id, err)
+	}
+	if got, err := plain.DecryptString(withID); err != nil || got != "derived" {
+		t.Fatalf("plain cipher could not open derived-ID envelope: %q, %v", got, err)
+	}
+
+	withoutID, _ := plain.EncryptString("no id")
+	if id, err := EnvelopeKeyID(withoutID); err != nil || id != nil {
+		t.Fatalf("EnvelopeKeyID() without ID = %x, %v", id, err)
+	}
+	if _, err := EnvelopeKeyID("plain"); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("plaintext error = %v", err)
+	}
+	var nilCipher *Cipher
+	if nilCipher.KeyID() != nil {
+		t.Fatal("nil cipher returned a key ID")
+	}
+}
+
+func TestKeyIDValidation(t *testing.T) {
+	for _, id := range [][]byte{nil, {}, make([]byte, maxKeyIDSize+1)} {
+		if _, err := NewCipher(testKey, WithKeyID(id)); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%d-byte key ID error = %v", len(id), err)
+		}
+	}
+	c, _ := NewCipher(testKey)
+	for _, header := range [][]byte{
+		{envelopeVersion, modeKeyID},
+		{envelopeVersion, modeKeyID, 0},
+		{envelopeVersion, modeKeyID, maxKeyIDSize + 1},
+		{envelopeVersion, modeKeyID, 4, 'a'},
+	} {
+		envelope := prefix + base64.RawURLEncoding.EncodeToString(append(header, make([]byte, 28)...))
+		if _, err := c.Open(envelope, nil); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("header %x error = %v", header, err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package secure
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Keyring is a Codec that seals with a primary Cipher and opens envelopes
+// sealed with the primary or any retired Cipher. Envelopes sealed by a Keyring
+// always carry the primary's key ID, so Open selects the matching key without
+// trial decryption. A Keyring is safe for concurrent use.
+type Keyring struct {
+	mu       sync.RWMutex
+	primary  *Cipher
+	keys     []*Cipher // primary first, then retired keys in insertion order
+	wrappers []KeyWrapper
+}
+
+// NewKeyring creates a keyring that seals with primary and can additionally
+// open envelopes sealed with any of the retired ciphers. Every cipher must
+// have a distinct KeyID.
+func NewKeyring(primary *Cipher, retired ...*Cipher) (*Keyring, error) {
+	if err := primary.validate(); err != nil {
+		return nil, err
+	}
+	k := &Keyring{primary: primary, keys: []*Cipher{primary}}
+	for _, c := range retired {
+		if err := k.add(c); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *Keyring) validate() error {
+	if k == nil {
+		return ErrUnconfigured
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.primary == nil {
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// Add registers a retired cipher that can open but not seal.
+func (k *Keyring) Add(c *Cipher) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.add(c)
+}
+
+func (k *Keyring) add(c *Cipher) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if k.lookup(c.keyID) != nil || findWrapper(k.wrappers, c.keyID) != nil {
+		return fmt.Errorf("secure: duplicate key ID %x", c.keyID)
+	}
+	k.keys = append(k.keys, c)
+	return nil
+}
+
+// AddKeyWrapper registers an external KeyWrapper that opens data key
+// envelopes sealed under its key ID. Key wrappers cannot be promoted.
+func (k *Keyring) AddKeyWrapper(w KeyWrapper) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	if w == nil {
+		return ErrUnconfigured
+	}
+	if err := validateWrapperID(w); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := w.KeyID()
+	if k.lookup(id) != nil || findWrapper(k.wrappers, id) != nil {
+		return fmt.Errorf("secure: duplicate key ID %x", id)
+	}
+	k.wrappers = append(k.wrappers, w)
+	return nil
+}
+
+// Promote makes the cipher with the given key ID the primary. The previous
+// primary is retired: it still opens envelopes but no longer seals.
+func (k *Keyring) Promote(id []byte) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	c := k.lookup(id)
+	if c == nil {
+		return fmt.Errorf("%w: no key with ID %x", ErrKeyMismatch, id)
+	}
+	k.primary = c
+	k.keys = append([]*Cipher{c}, deleteCipher(k.keys, c)...)
+	return nil
+}
+
+// Remove drops a retired cipher or key wrapper. Envelopes sealed with it can
+// no longer be opened by the keyring. The primary cannot be removed.
+func (k *Keyring) Remove(id []byte) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	c := k.lookup(id)
+	if c == nil {
+		if w := findWrapper(k.wrappers, id); w != nil {
+			k.wrappers = slices.DeleteFunc(slices.Clone(k.wrappers), func(x KeyWrapper) bool { return x == w })
+			return nil
+		}
+		return fmt.Errorf("%w: no key with ID %x", ErrKeyMismatch, id)
+	}
+	if c == k.primary {
+		return errors.New("secure: cannot remove the primary key")
+	}
+	k.keys = deleteCipher(k.keys, c)
+	return nil
+}
+
+// PrimaryKeyID returns the key ID used for new envelopes.
+func (k *Keyring) PrimaryKeyID() []byte {
+	if k.validate() != nil {
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary.KeyID()
+}
+
+// KeyIDs returns the IDs of all keys, primary first.
+func (k *Keyring) KeyIDs() [][]byte {
+	if k.validate() != nil {
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([][]byte, len(k.keys))
+	for i, c := range k.keys {
+		ids[i] = c.KeyID()
+	}
+	return ids
+}
+
+// Seal encrypts plaintext with the primary key and records its key ID.
+func (k *Keyring) Seal(plaintext, additionalData []byte) (string, error) {
+	return k.SealWithMetadata(plaintext, additionalData, nil)
+}
+
+// SealWithMetadata is like Seal, but also stores metadata in the envelope
+// header, where it is authenticated but not encrypted. The limits are those
+// of Cipher.SealWithMetadata.
+func (k *Keyring) SealWithMetadata(plaintext, additionalData []byte, metadata map[string]string) (string, error) {
+	if err := k.validate(); err != nil {
+		return "", err
+	}
+	k.mu.RLock()
+	primary := k.primary
+	k.mu.RUnlock()
+	cfg, err := primary.cfg.withMetadata(metadata)
+	if err != nil {
+		return "", err
+	}
+	return primary.seal(plaintext, additionalData, cfg, true)
+}
+
+// Open selects the key named by the envelope's key ID and decrypts it.
+// Envelopes without a key ID are tried against every key, primary first.
+func (k *Keyring) Open(envelope string, additionalData []byte) ([]byte, error) {
+	plaintext, _, err := k.OpenWithMetadata(envelope, additionalData)
+	return plaintext, err
+}
+
+// OpenWithMetadata is like Open, but also returns the authenticated metadata
+// stored by SealWithMetadata, or nil if there is none.
+func (k *Keyring) OpenWithMetadata(envelope string, additionalData []byte) ([]byte, map[string]string, error) {
+	if err := k.validate(); err != nil {
+		return nil, nil, err
+	}
+	k.mu.RLock()
+	primary, keys, wrappers := k.primary, k.keys, k.wrappers
+	k.mu.RUnlock()
+	if len(additionalData) > primary.cfg.maxEnvelope {
+		return nil, nil, ErrLimitExceeded
+	}
+	env, err := parseEnvelope(envelope, primary.cfg.maxEnvelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := openWithKeys(keys, wrappers, env, additionalData)
+	if plaintext, err = primary.cfg.checkExpiry(env, plaintext, err); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, envelopeMetadata(env), nil
+}
+
+// openWithKeys opens env with the cipher or wrapper it names, or tries every
+// cipher if it names none.
+func openWithKeys(keys []*Cipher, wrappers []KeyWrapper, env envelope, additionalData []byte) ([]byte, error) {
+	switch env.mode {
+	case modeKeyID, modeDataKey:
+		id := env.fields[1 : 1+int(env.fields[0])]
+		if c := findCipher(keys, id); c != nil {
+			return c.open(env, additionalData)
+		}
+		if w := findWrapper(wrappers, id); w != nil && env.mode == modeDataKey {
+			return openDataKey(w, env, additionalData)
+		}
+		return nil, fmt.Errorf("%w: no key with ID %x", ErrKeyMismatch, id)
+	case modeMulti:
+		for _, c := range keys {
+			plaintext, err := openMulti(c, env, additionalData)
+			if !errors.Is(err, ErrKeyMismatch) {
+				return plaintext, err
+			}
+		}
+		return nil, ErrKeyMismatch
+	case modeKey:
+		for _, c := range keys {
+			if plaintext, err := c.open(env, additionalData); err == nil {
+				return plaintext, nil
+			}
+		}
+		return nil, ErrAuthentication
+	default:
+		return nil, fmt.Errorf("%w: envelope requires a password", ErrInvalidEnvelope)
+	}
+}
+
+// EncryptString encrypts a UTF-8 string without additional data.
+func (k *Keyring) EncryptString(plaintext string) (string, error) {
+	return k.Seal([]byte(plaintext), nil)
+}
+
+// DecryptString decrypts a UTF-8 string without additional data.
+func (k *Keyring) DecryptString(envelope string) (string, error) {
+	b, err := k.Open(envelope, nil)
+	return string(b), err
+}
+
+func (k *Keyring) lookup(id []byte) *Cipher {
+	return findCipher(k.keys, id)
+}
+
+func findCipher(keys []*Cipher, id []byte) *Cipher {
+	for _, c := range keys {
+		if string(c.keyID) == string(id) {
+			return c
+		}
+	}
+	return nil
+}
+
+func findWrapper(wrappers []KeyWrapper, id []byte) KeyWrapper {
+	for _, w := range wrappers {
+		if string(w.KeyID()) == string(id) {
+			return w
+		}
+	}
+	return nil
+}
+
+func deleteCipher(keys []*Cipher, c *Cipher) []*Cipher {
+	out := make([]*Cipher, 0, len(keys))
+	for _, k := range keys {
+		if k != c {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, _ := NewCipher(testKey, WithKeyID([]byte("old")))
+	newKey, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize), WithKeyID([]byte("new")))
+
+	k, err := NewKeyring(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := k.EncryptString("before rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := NewCipher(testKey)
+	unlabelled, _ := legacy.EncryptString("no key ID")
+
+	if err := k.Add(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Promote([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if id := k.PrimaryKeyID(); string(id) != "new" {
+		t.Fatalf("PrimaryKeyID() = %q", id)
+	}
+	after, err := k.EncryptString("after rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := EnvelopeKeyID(after); string(id) != "new" {
+		t.Fatalf("sealed with key %q", id)
+	}
+	for envelope, want := range map[string]string{before: "before rotation", after: "after rotation", unlabelled: "no key ID"} {
+		if got, err := k.DecryptString(envelope); err != nil || got != want {
+			t.Fatalf("DecryptString() = %q, %v; want %q", got, err, want)
+		}
+	}
+
+	if err := k.Remove([]byte("new")); err == nil {
+		t.Fatal("removed the primary key")
+	}
+	if err := k.Remove([]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.DecryptString(before); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("removed key error = %v", err)
+	}
+	if _, err := k.DecryptString(unlabelled); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("unlabelled removed key error = %v", err)
+	}
+	if ids := k.KeyIDs(); len(ids) != 1 || string(ids[0]) != "new" {
+		t.Fatalf("KeyIDs() = %q", ids)
+	}
+}
+
+func TestKeyringValidation(t *testing.T) {
+	a, _ := NewCipher(testKey)
+	b, _ := NewCipher(testKey)
+	if _, err := NewKeyring(a, b); err == nil {
+		t.Fatal("accepted duplicate key IDs")
+	}
+	if _, err := NewKeyring(nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil primary error = %v", err)
+	}
+	if _, err := NewKeyring(a, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil retired error = %v", err)
+	}
+	k, _ := NewKeyring(a)
+	if err := k.Promote([]byte("missing")); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("Promote error = %v", err)
+	}
+	if err := k.Remove([]byte("missing")); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("Remove error = %v", err)
+	}
+	p, _ := NewPasswordCipher([]byte("password"))
+	envelope, _ := p.EncryptString("password")
+	if _, err := k.DecryptString(envelope); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("password envelope error = %v", err)
+	}
+
+	var zero Keyring
+	for name, call := range map[string]func() error{
+		"Seal":    func() error { _, err := zero.Seal(nil, nil); return err },
+		"Open":    func() error { _, err := zero.Open("", nil); return err },
+		"Add":     func() error { return zero.Add(a) },
+		"Promote": func() error { return zero.Promote(nil) },
+		"Remove":  func() error { return zero.Remove(nil) },
+	} {
+		if err := call(); !errors.Is(err, ErrUnconfigured) {
+			t.Fatalf("zero Keyring %s error = %v", name, err)
+		}
+	}
+	if zero.PrimaryKeyID() != nil || zero.KeyIDs() != nil {
+		t.Fatal("zero Keyring reported key IDs")
+	}
+}
+
+func TestKeyringJSONAndConcurrency(t *testing.T) {
+	a, _ := NewCipher(testKey, WithKeyID([]byte("a")))
+	b, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize), WithKeyID([]byte("b")))
+	k, _ := NewKeyring(a, b)
+	secret, _ := NewEncryptedString(k, "json value")
+	data, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				_ = k.Promote([]byte{'a' + byte(i/2%2)})
+			}
+			decoded, _ := NewEncryptedString(k, "")
+			if err := json.Unmarshal(data, &decoded); err != nil || decoded.Value() != "json value" {
+				t.Errorf("Unmarshal() = %q, %v", decoded.Value(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,242 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// KeyWrapper protects the data keys used for envelope encryption. An
+// implementation may delegate to an external key management system so that
+// the master key never enters the process. KeyID must return a stable
+// identifier of 1 to 64 bytes; it is stored in the clear next to every
+// wrapped key. A *Cipher is a KeyWrapper.
+type KeyWrapper interface {
+	KeyID() []byte
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// WrapKey encrypts a data key with a key derived from the Cipher's key.
+func (c *Cipher) WrapKey(dataKey []byte) ([]byte, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c.wrapDataKey(dataKey)
+}
+
+// UnwrapKey decrypts a data key produced by WrapKey.
+func (c *Cipher) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c.unwrapDataKey(wrapped)
+}
+
+// EnvelopeCipher is a Codec that encrypts each envelope and stream with a
+// fresh data key protected by a KeyWrapper. It produces the same format as a
+// Cipher configured with WithDataKeys.
+type EnvelopeCipher struct {
+	wrapper KeyWrapper
+	cfg     config
+}
+
+// NewEnvelopeCipher creates an envelope encryption context around wrapper.
+func NewEnvelopeCipher(wrapper KeyWrapper, opts ...Option) (*EnvelopeCipher, error) {
+	if wrapper == nil {
+		return nil, ErrUnconfigured
+	}
+	if err := validateWrapperID(wrapper); err != nil {
+		return nil, err
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvelopeCipher{wrapper: wrapper, cfg: cfg}, nil
+}
+
+func validateWrapperID(w KeyWrapper) error {
+	if id := w.KeyID(); len(id) == 0 || len(id) > maxKeyIDSize {
+		return fmt.Errorf("%w: key ID must be between 1 and %d bytes", ErrLimitExceeded, maxKeyIDSize)
+	}
+	return nil
+}
+
+func (e *EnvelopeCipher) validate() error {
+	if e == nil || e.wrapper == nil || e.cfg.maxEnvelope == 0 || e.cfg.rand == nil {
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// Seal encrypts plaintext under a new data key wrapped by the KeyWrapper.
+func (e *EnvelopeCipher) Seal(plaintext, additionalData []byte) (string, error) {
+	return e.SealWithMetadata(plaintext, additionalData, nil)
+}
+
+// SealWithMetadata is like Seal, but also stores metadata in the envelope
+// header, where it is authenticated but not encrypted. The limits are those
+// of Cipher.SealWithMetadata.
+func (e *EnvelopeCipher) SealWithMetadata(plaintext, additionalData []byte, metadata map[string]string) (string, error) {
+	if err := e.validate(); err != nil {
+		return "", err
+	}
+	cfg, err := e.cfg.withMetadata(metadata)
+	if err != nil {
+		return "", err
+	}
+	return sealDataKey(e.wrapper, plaintext, additionalData, cfg)
+}
+
+// Open unwraps the envelope's data key and decrypts it.
+func (e *EnvelopeCipher) Open(envelope string, additionalData []byte) ([]byte, error) {
+	plaintext, _, err := e.OpenWithMetadata(envelope, additionalData)
+	return plaintext, err
+}
+
+// OpenWithMetadata is like Open, but also returns the authenticated metadata
+// stored by SealWithMetadata, or nil if there is none.
+func (e *EnvelopeCipher) OpenWithMetadata(envelope string, additionalData []byte) ([]byte, map[string]string, error) {
+	if err := e.validate(); err != nil {
+		return nil, nil, err
+	}
+	if len(additionalData) > e.cfg.maxEnvelope {
+		return nil, nil, ErrLimitExceeded
+	}
+	env, err := parseEnvelope(envelope, e.cfg.maxEnvelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.mode != modeDataKey {
+		return nil, nil, fmt.Errorf("%w: envelope does not contain a data key", ErrInvalidEnvelope)
+	}
+	plaintext, err := openDataKey(e.wrapper, env, additionalData)
+	if plaintext, err = e.cfg.checkExpiry(env, plaintext, err); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, envelopeMetadata(env), nil
+}
+
+// EncryptString encrypts a UTF-8 string without additional data.
+func (e *EnvelopeCipher) EncryptString(plaintext string) (string, error) {
+	return e.Seal([]byte(plaintext), nil)
+}
+
+// DecryptString decrypts a UTF-8 string without additional data.
+func (e *EnvelopeCipher) DecryptString(envelope string) (string, error) {
+	b, err := e.Open(envelope, nil)
+	return string(b), err
+}
+
+// NewEncryptWriter returns an authenticated streaming writer that encrypts
+// under a new wrapped data key. Close must be called to write the
+// authenticated final record.
+func (e *EnvelopeCipher) NewEncryptWriter(w io.Writer, opts ...StreamOption) (io.WriteCloser, error) {
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, errors.New("secure: nil writer")
+	}
+	return newDataKeyWriter(e.wrapper, w, e.cfg, sc)
+}
+
+// NewDecryptReader reads and authenticates a data key stream.
+func (e *EnvelopeCipher) NewDecryptReader(r io.Reader, opts ...StreamOption) (io.Reader, error) {
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readStreamHeader(r, modeDataKey)
+	if err != nil {
+		return nil, err
+	}
+	return newDataKeyReader(e.wrapper, r, h, sc)
+}
+
+// FileKeyWrapper is a KeyWrapper backed by a master key stored in a local
+// file. It is intended for development, tests, and offline deployments that
+// do not use an external key management system.
+type FileKeyWrapper struct {
+	c *Cipher
+}
+
+// NewFileKeyWrapper loads a 32-byte master key from path. The file holds the
+// key either as standard base64 text or as raw bytes. Options are applied to
+// the underlying Cipher, so WithKeyID can name the key.
+func NewFileKeyWrapper(path string, opts ...Option) (*FileKeyWrapper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+	key := data
+	if len(data) != keySize {
+		key, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return nil, fmt.Errorf("secure: key file %s: invalid encoding", path)
+		}
+		defer clear(key)
+	}
+	c, err := NewCipher(key, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("secure: key file %s: %w", path, err)
+	}
+	return &FileKeyWrapper{c: c}, nil
+}
+
+// GenerateKeyFile writes a new random master key to path as base64 text. It
+// refuses to overwrite an existing file and creates the file readable only by
+// its owner.
+func GenerateKeyFile(path string) error {
+	key := make([]byte, keySize)
+	defer clear(key)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, base64.StdEncoding.EncodeToString(key)+"\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// KeyID returns the identifier of the file's master key.
+func (f *FileKeyWrapper) KeyID() []byte {
+	if f == nil {
+		return nil
+	}
+	return f.c.KeyID()
+}
+
+// WrapKey encrypts a data key with the file's master key.
+func (f *FileKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	if f == nil {
+		return nil, ErrUnconfigured
+	}
+	return f.c.WrapKey(dataKey)
+}
+
+// UnwrapKey decrypts a data key produced by WrapKey.
+func (f *FileKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if f == nil {
+		return nil, ErrUnconfigured
+	}
+	return f.c.UnwrapKey(wrapped)
+}
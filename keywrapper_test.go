@@ -0,0 +1,232 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// remoteWrapper simulates a key management system that never exposes its key.
+type remoteWrapper struct {
+	id      []byte
+	backend *Cipher
+	calls   int
+	fail    error
+}
+
+func (r *remoteWrapper) KeyID() []byte { return r.id }
+
+func (r *remoteWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	r.calls++
+	if r.fail != nil {
+		return nil, r.fail
+	}
+	return r.backend.WrapKey(dataKey)
+}
+
+func (r *remoteWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	r.calls++
+	if r.fail != nil {
+		return nil, r.fail
+	}
+	return r.backend.UnwrapKey(wrapped)
+}
+
+func TestFileKeyWrapperEnvelopeCipher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := GenerateKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateKeyFile(path); err == nil {
+		t.Fatal("GenerateKeyFile overwrote an existing file")
+	}
+	wrapper, err := NewFileKeyWrapper(path, WithKeyID([]byte("local")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEnvelopeCipher(wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := e.Seal([]byte("offline"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := EnvelopeKeyID(envelope); string(id) != "local" {
+		t.Fatalf("EnvelopeKeyID() = %q", id)
+	}
+	if got, err := e.Open(envelope, []byte("aad")); err != nil || string(got) != "offline" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+
+	var encrypted bytes.Buffer
+	w, err := e.NewEncryptWriter(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(w, "offline stream")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := e.NewDecryptReader(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "offline stream" {
+		t.Fatalf("ReadAll() = %q, %v", got, err)
+	}
+
+	plain, _ := NewCipher(testKey)
+	keyEnvelope, _ := plain.EncryptString("key mode")
+	if _, err := e.DecryptString(keyEnvelope); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("key envelope error = %v", err)
+	}
+}
+
+func TestKeyFileFormats(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw.key")
+	encoded := filepath.Join(dir, "encoded.key")
+	bad := filepath.Join(dir, "bad.key")
+	_ = os.WriteFile(raw, testKey, 0o600)
+	_ = os.WriteFile(encoded, []byte("  "+base64.StdEncoding.EncodeToString(testKey)+"\n"), 0o600)
+	_ = os.WriteFile(bad, []byte("not a key"), 0o600)
+
+	c, _ := NewCipher(testKey)
+	for _, path := range []string{raw, encoded} {
+		w, err := NewFileKeyWrapper(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w.KeyID(), c.KeyID()) {
+			t.Fatalf("%s: key ID %x, want %x", path, w.KeyID(), c.KeyID())
+		}
+	}
+	if _, err := NewFileKeyWrapper(bad); err == nil {
+		t.Fatal("accepted malformed key file")
+	}
+	if _, err := NewFileKeyWrapper(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing file error = %v", err)
+	}
+	_ = os.WriteFile(bad, []byte(base64.StdEncoding.EncodeToString(make([]byte, 16))), 0o600)
+	if _, err := NewFileKeyWrapper(bad); err == nil {
+		t.Fatal("accepted short key")
+	}
+
+	var nilWrapper *FileKeyWrapper
+	if nilWrapper.KeyID() != nil {
+		t.Fatal("nil wrapper returned a key ID")
+	}
+	if _, err := nilWrapper.WrapKey(nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil WrapKey error = %v", err)
+	}
+	if _, err := nilWrapper.UnwrapKey(nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil UnwrapKey error = %v", err)
+	}
+}
+
+func TestRemoteKeyWrapper(t *testing.T) {
+	backend, _ := NewCipher(bytes.Repeat([]byte{0x33}, keySize))
+	remote := &remoteWrapper{id: []byte("kms/key-1"), backend: backend}
+	e, err := NewEnvelopeCipher(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := e.EncryptString("kms secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := e.DecryptString(envelope); err != nil || got != "kms secret" {
+		t.Fatalf("DecryptString() = %q, %v", got, err)
+	}
+	if remote.calls != 2 {
+		t.Fatalf("wrapper called %d times, want 2", remote.calls)
+	}
+
+	local, _ := NewCipher(testKey, WithKeyID([]byte("local")), WithDataKeys())
+	moved, err := RewrapDataKey(envelope, remote, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := local.DecryptString(moved); err != nil || got != "kms secret" {
+		t.Fatalf("moved DecryptString() = %q, %v", got, err)
+	}
+
+	ring, _ := NewKeyring(local)
+	if err := ring.AddKeyWrapper(remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddKeyWrapper(remote); err == nil {
+		t.Fatal("accepted duplicate key wrapper")
+	}
+	if got, err := ring.DecryptString(envelope); err != nil || got != "kms secret" {
+		t.Fatalf("Keyring DecryptString() = %q, %v", got, err)
+	}
+	if err := ring.Remove(remote.id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ring.DecryptString(envelope); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("removed wrapper error = %v", err)
+	}
+
+	outage := errors.New("kms unavailable")
+	remote.fail = outage
+	if _, err := e.DecryptString(envelope); !errors.Is(err, outage) {
+		t.Fatalf("outage Open error = %v", err)
+	}
+	if _, err := e.EncryptString("x"); !errors.Is(err, outage) {
+		t.Fatalf("outage Seal error = %v", err)
+	}
+	if _, err := e.NewEncryptWriter(io.Discard); !errors.Is(err, outage) {
+		t.Fatalf("outage writer error = %v", err)
+	}
+}
+
+func TestEnvelopeCipherValidation(t *testing.T) {
+	if _, err := NewEnvelopeCipher(nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil wrapper error = %v", err)
+	}
+	backend, _ := NewCipher(testKey)
+	for _, id := range [][]byte{nil, make([]byte, maxKeyIDSize+1)} {
+		if _, err := NewEnvelopeCipher(&remoteWrapper{id: id, backend: backend}); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%d-byte ID error = %v", len(id), err)
+		}
+	}
+	if _, err := NewEnvelopeCipher(backend, nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("nil option error = %v", err)
+	}
+	e, _ := NewEnvelopeCipher(&remoteWrapper{id: []byte("broken")})
+	if _, err := e.EncryptString("x"); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("unconfigured backend error = %v", err)
+	}
+
+	var zero EnvelopeCipher
+	for name, call := range map[string]func() error{
+		"Seal":             func() error { _, err := zero.Seal(nil, nil); return err },
+		"Open":             func() error { _, err := zero.Open("", nil); return err },
+		"NewEncryptWriter": func() error { _, err := zero.NewEncryptWriter(io.Discard); return err },
+		"NewDecryptReader": func() error { _, err := zero.NewDecryptReader(bytes.NewReader(nil)); return err },
+	} {
+		if err := call(); !errors.Is(err, ErrUnconfigured) {
+			t.Fatalf("zero EnvelopeCipher %s error = %v", name, err)
+		}
+	}
+	e, _ = NewEnvelopeCipher(backend)
+	if _, err := e.NewEncryptWriter(nil); err == nil {
+		t.Fatal("accepted nil writer")
+	}
+	if _, err := e.Open(prefix, make([]byte, defaultMaxEnvelope+1)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("large AAD error = %v", err)
+	}
+	ring, _ := NewKeyring(backend)
+	if err := ring.AddKeyWrapper(nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil key wrapper error = %v", err)
+	}
+	if err := ring.AddKeyWrapper(backend); err == nil {
+		t.Fatal("accepted key wrapper that duplicates a cipher")
+	}
+}
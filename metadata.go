@@ -0,0 +1,93 @@
+package secure
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"unicode/utf8"
+)
+
+// maxMetadataSize bounds the encoded metadata of one envelope. Each entry is
+// encoded as keyLen(1) key valueLen(2) value, sorted by key.
+const maxMetadataSize = 8 << 10
+
+// withMetadata returns a copy of c that records metadata in new envelopes.
+func (c config) withMetadata(metadata map[string]string) (config, error) {
+	encoded, err := encodeMetadata(metadata)
+	if err != nil {
+		return config{}, err
+	}
+	c.metadata = encoded
+	return c, nil
+}
+
+func encodeMetadata(metadata map[string]string) (string, error) {
+	var b []byte
+	for _, k := range slices.Sorted(maps.Keys(metadata)) {
+		v := metadata[k]
+		if len(k) == 0 || len(k) > 255 || !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return "", errors.New("secure: metadata keys must be 1 to 255 bytes and entries valid UTF-8")
+		}
+		if len(b)+1+len(k)+2+len(v) > maxMetadataSize {
+			return "", fmt.Errorf("%w: metadata exceeds %d bytes", ErrLimitExceeded, maxMetadataSize)
+		}
+		b = append(b, byte(len(k)))
+		b = append(b, k...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+		b = append(b, v...)
+	}
+	return string(b), nil
+}
+
+// decodeMetadata decodes metadata, reporting false unless it is in the
+// canonical form written by encodeMetadata.
+func decodeMetadata(b []byte) (map[string]string, bool) {
+	if len(b) == 0 || len(b) > maxMetadataSize {
+		return nil, false
+	}
+	m := make(map[string]string)
+	var last string
+	for len(b) > 0 {
+		n := int(b[0])
+		if n == 0 || len(b) < 1+n+2 {
+			return nil, false
+		}
+		k := string(b[1 : 1+n])
+		b = b[1+n:]
+		size := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+size || len(m) > 0 && k <= last {
+			return nil, false
+		}
+		v := string(b[2 : 2+size])
+		b = b[2+size:]
+		if !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return nil, false
+		}
+		m[k], last = v, k
+	}
+	return m, true
+}
+
+// envelopeMetadata returns the metadata recorded in env, or nil.
+func envelopeMetadata(env envelope) map[string]string {
+	if env.ext.metadata == "" {
+		return nil
+	}
+	m, _ := decodeMetadata([]byte(env.ext.metadata))
+	return m
+}
+
+// EnvelopeMetadata returns the metadata stored by SealWithMetadata without
+// decrypting the envelope, or nil if there is none. The metadata is not
+// authenticated until the envelope is opened. Like EnvelopeKeyID, it rejects
+// envelopes over the default 16 MiB size limit with ErrLimitExceeded; use
+// OpenWithMetadata for larger ones.
+func EnvelopeMetadata(envelope string) (map[string]string, error) {
+	env, err := parseEnvelope(envelope, defaultMaxEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	return envelopeMetadata(env), nil
+}
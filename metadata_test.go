@@ -0,0 +1,166 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSealWithMetadata(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	metadata := map[string]string{"content-type": "application/json", "schema": "3", "record": "ü-42", "empty": ""}
+	envelope, err := c.SealWithMetadata([]byte(`{"a":1}`), []byte("bucket/key"), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := EnvelopeMetadata(envelope)
+	if err != nil || !maps.Equal(public, metadata) {
+		t.Fatalf("EnvelopeMetadata() = %v, %v", public, err)
+	}
+	got, opened, err := c.OpenWithMetadata(envelope, []byte("bucket/key"))
+	if err != nil || string(got) != `{"a":1}` || !maps.Equal(opened, metadata) {
+		t.Fatalf("OpenWithMetadata() = %q, %v, %v", got, opened, err)
+	}
+	if got, err := c.Open(envelope, []byte("bucket/key")); err != nil || string(got) != `{"a":1}` {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	if _, _, err := c.OpenWithMetadata(envelope, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+
+	// The metadata is authenticated.
+	tampered := strings.Replace(string(mustDecode(t, envelope)), "application/json", "application/text", 1)
+	if _, err := c.Open(prefix+base64.RawURLEncoding.EncodeToString([]byte(tampered)), []byte("bucket/key")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("tampered metadata error = %v", err)
+	}
+
+	plain, _ := c.EncryptString("x")
+	if m, err := EnvelopeMetadata(plain); err != nil || m != nil {
+		t.Fatalf("EnvelopeMetadata(plain) = %v, %v", m, err)
+	}
+	if _, m, err := c.OpenWithMetadata(plain, nil); err != nil || m != nil {
+		t.Fatalf("OpenWithMetadata(plain) = %v, %v", m, err)
+	}
+	if empty, _ := c.SealWithMetadata([]byte("x"), nil, map[string]string{}); len(empty) != len(plain) {
+		t.Fatal("empty metadata changed the envelope format")
+	}
+	if _, err := EnvelopeMetadata("SEC2.!"); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("EnvelopeMetadata(invalid) error = %v", err)
+	}
+}
+
+func TestMetadataAcrossCodecs(t *testing.T) {
+	metadata := map[string]string{"type": "invite"}
+	dataKeys, _ := NewCipher(testKey, WithKeyID([]byte("k1")), WithDataKeys())
+	ring, _ := NewKeyring(dataKeys)
+	password, _ := NewPasswordCipher([]byte("correct horse"))
+	wrapped, _ := NewEnvelopeCipher(dataKeys)
+	for name, c := range map[string]interface {
+		SealWithMetadata(plaintext, additionalData []byte, metadata map[string]string) (string, error)
+		OpenWithMetadata(envelope string, additionalData []byte) ([]byte, map[string]string, error)
+	}{
+		"data key": dataKeys,
+		"keyring":  ring,
+		"password": password,
+		"envelope": wrapped,
+	} {
+		envelope, err := c.SealWithMetadata([]byte("payload"), nil, metadata)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, m, err := c.OpenWithMetadata(envelope, nil)
+		if err != nil || string(got) != "payload" || !maps.Equal(m, metadata) {
+			t.Fatalf("%s: OpenWithMetadata() = %q, %v, %v", name, got, m, err)
+		}
+		if _, err := c.SealWithMetadata(nil, nil, map[string]string{"": "x"}); err == nil || errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("%s: empty key error = %v", name, err)
+		}
+	}
+
+	// An EnvelopeCipher writes the format of a Cipher with data keys.
+	envelope, _ := wrapped.SealWithMetadata([]byte("payload"), nil, metadata)
+	if _, m, err := dataKeys.OpenWithMetadata(envelope, nil); err != nil || !maps.Equal(m, metadata) {
+		t.Fatalf("OpenWithMetadata(EnvelopeCipher) = %v, %v", m, err)
+	}
+
+	// Metadata survives rewrapping and is returned only for unexpired envelopes.
+	envelope, _ = dataKeys.SealWithMetadata([]byte("payload"), nil, metadata)
+	next, _ := NewCipher(bytes.Repeat([]byte{0x24}, keySize), WithKeyID([]byte("k2")))
+	rotated, _ := RewrapDataKey(envelope, dataKeys, next)
+	if _, m, err := ring.OpenWithMetadata(rotated, nil); !errors.Is(err, ErrKeyMismatch) || m != nil {
+		t.Fatalf("OpenWithMetadata(unknown key) = %v, %v", m, err)
+	}
+	ring.Add(next)
+	if _, m, err := ring.OpenWithMetadata(rotated, nil); err != nil || !maps.Equal(m, metadata) {
+		t.Fatalf("OpenWithMetadata(rewrapped) = %v, %v", m, err)
+	}
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	expiring, _ := NewCipher(testKey, WithExpiry(time.Minute), WithClock(clock.now))
+	envelope, _ = expiring.SealWithMetadata([]byte("payload"), nil, metadata)
+	clock.t = clock.t.Add(time.Hour)
+	if _, m, err := expiring.OpenWithMetadata(envelope, nil); !errors.Is(err, ErrExpired) || m != nil {
+		t.Fatalf("OpenWithMetadata(expired) = %v, %v", m, err)
+	}
+}
+
+func TestMetadataLimits(t *testing.T) {
+	c, _ := NewCipher(testKey, WithMaxEnvelopeSize(256))
+	if _, err := c.SealWithMetadata([]byte("x"), nil, map[string]string{"k": strings.Repeat("v", 240)}); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("envelope limit error = %v", err)
+	}
+	p, _ := NewPasswordCipher([]byte("x"), WithPasswordMaxEnvelopeSize(256))
+	if _, err := p.SealWithMetadata([]byte("x"), nil, map[string]string{"k": strings.Repeat("v", 240)}); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("password envelope limit error = %v", err)
+	}
+	big, _ := NewCipher(testKey)
+	if _, err := big.SealWithMetadata(nil, nil, map[string]string{"k": strings.Repeat("v", maxMetadataSize)}); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("metadata limit error = %v", err)
+	}
+	for name, m := range map[string]map[string]string{
+		"long key":      {strings.Repeat("k", 256): ""},
+		"invalid key":   {"\xff": ""},
+		"invalid value": {"k": "\xff"},
+	} {
+		if _, err := big.SealWithMetadata(nil, nil, m); err == nil || errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("%s error = %v", name, err)
+		}
+	}
+	var unconfigured *Cipher
+	if _, err := unconfigured.SealWithMetadata(nil, nil, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil SealWithMetadata error = %v", err)
+	}
+	if _, _, err := unconfigured.OpenWithMetadata("", nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil OpenWithMetadata error = %v", err)
+	}
+
+	header := streamPrefix(modeKey, extensions{alg: AES256GCM, metadata: "\x01k\x00\x00"})
+	if _, err := big.NewDecryptReader(bytes.NewReader(append(header, make([]byte, saltSize)...))); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("stream with metadata error = %v", err)
+	}
+
+	for name, encoded := range map[string]string{
+		"empty":     "",
+		"empty key": "\x00\x00\x00",
+		"short":     "\x01k\x00\x02v",
+		"unsorted":  "\x01b\x00\x00\x01a\x00\x00",
+		"duplicate": "\x01a\x00\x00\x01a\x00\x00",
+		"invalid":   "\x01\xff\x00\x00",
+	} {
+		if _, ok := decodeMetadata([]byte(encoded)); ok {
+			t.Fatalf("decodeMetadata(%s) succeeded", name)
+		}
+	}
+}
+
+func mustDecode(t *testing.T, envelope string) []byte {
+	t.Helper()
+	packed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
@@ -0,0 +1,414 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	maxRecipients         = 16
+	maxPasswordRecipients = 1
+	maxStanzaSize         = 2048
+
+	stanzaKey      = byte(1)
+	stanzaPassword = byte(2)
+	stanzaX25519   = byte(3)
+	stanzaHybrid   = byte(4)
+	// stanzaDerivedKey is a stanzaKey preceded by the length-prefixed
+	// derivation path of the Cipher that wrapped it, so that its ancestors can
+	// derive it again.
+	stanzaDerivedKey = byte(5)
+)
+
+// Recipient receives a wrapped copy of the content key of a multi-recipient
+// envelope or stream. *Cipher, *PasswordCipher, *X25519Recipient, and
+// *HybridRecipient are recipients; each opens the result with its usual Open
+// or NewDecryptReader.
+type Recipient interface {
+	wrapContentKey(contentKey []byte) (stanza, error)
+}
+
+// stanza is one recipient's entry in a multi-recipient header.
+type stanza struct {
+	kind byte
+	body []byte
+}
+
+type contentKeyUnwrapper interface {
+	unwrapContentKey(stanzas []stanza) ([]byte, error)
+}
+
+// MultiRecipient seals envelopes and streams that any of several independent
+// recipients can open. The payload is encrypted once under a random content
+// key, and each recipient stores its own wrapped copy of that key in the
+// header.
+type MultiRecipient struct {
+	recipients []Recipient
+	cfg        config
+}
+
+// NewMultiRecipient creates a sealer for up to 16 recipients. At most one
+// recipient may be a *PasswordCipher, which bounds the Argon2id work a
+// hostile header can demand.
+func NewMultiRecipient(recipients []Recipient, opts ...Option) (*MultiRecipient, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("secure: no recipients")
+	}
+	if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("%w: at most %d recipients", ErrLimitExceeded, maxRecipients)
+	}
+	passwords := 0
+	for _, r := range recipients {
+		if r == nil {
+			return nil, ErrUnconfigured
+		}
+		if _, ok := r.(*PasswordCipher); ok {
+			passwords++
+		}
+	}
+	if passwords > maxPasswordRecipients {
+		return nil, fmt.Errorf("%w: at most %d password recipient", ErrLimitExceeded, maxPasswordRecipients)
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiRecipient{recipients: append([]Recipient(nil), recipients...), cfg: cfg}, nil
+}
+
+func (m *MultiRecipient) validate() error {
+	if m == nil || len(m.recipients) == 0 || m.cfg.maxEnvelope == 0 || m.cfg.rand == nil {
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// newContentKey generates a content key and the encoded stanzas that wrap it
+// for every recipient.
+func (m *MultiRecipient) newContentKey() (contentKey, stanzas []byte, err error) {
+	contentKey = make([]byte, keySize)
+	if _, err := io.ReadFull(m.cfg.rand, contentKey); err != nil {
+		return nil, nil, fmt.Errorf("secure: generate content key: %w", err)
+	}
+	stanzas = []byte{byte(len(m.recipients))}
+	for _, r := range m.recipients {
+		s, err := r.wrapContentKey(contentKey)
+		if err != nil {
+			clear(contentKey)
+			return nil, nil, err
+		}
+		stanzas = append(stanzas, s.kind)
+		stanzas = binary.BigEndian.AppendUint16(stanzas, uint16(len(s.body)))
+		stanzas = append(stanzas, s.body...)
+	}
+	return contentKey, stanzas, nil
+}
+
+// Seal encrypts plaintext once and wraps the content key for every recipient.
+func (m *MultiRecipient) Seal(plaintext, additionalData []byte) (string, error) {
+	if err := m.validate(); err != nil {
+		return "", err
+	}
+	contentKey, stanzas, err := m.newContentKey()
+	if err != nil {
+		return "", err
+	}
+	defer clear(contentKey)
+	return sealWithKey(contentKey, modeMulti, stanzas, plaintext, additionalData, m.cfg)
+}
+
+// EncryptString encrypts a UTF-8 string without additional data.
+func (m *MultiRecipient) EncryptString(plaintext string) (string, error) {
+	return m.Seal([]byte(plaintext), nil)
+}
+
+// NewEncryptWriter returns an authenticated streaming writer that every
+// recipient can decrypt. Close must be called to write the authenticated
+// final record.
+func (m *MultiRecipient) NewEncryptWriter(w io.Writer, opts ...StreamOption) (io.WriteCloser, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, errors.New("secure: nil writer")
+	}
+	contentKey, stanzas, err := m.newContentKey()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(contentKey)
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(m.cfg.rand, salt); err != nil {
+		return nil, err
+	}
+	x := m.cfg.streamExtensions(sc)
+	header := append(streamPrefix(modeMulti, x), stanzas...)
+	header = append(header, salt...)
+	key, err := deriveStreamKey(contentKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, key, header, header, x, sc)
+}
+
+func openMulti(u contentKeyUnwrapper, env envelope, additionalData []byte) ([]byte, error) {
+	stanzas, _, _ := parseStanzas(env.fields)
+	contentKey, err := u.unwrapContentKey(stanzas)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(contentKey)
+	return openAEAD(contentKey, env, additionalData)
+}
+
+func newMultiReader(u contentKeyUnwrapper, r io.Reader, h streamHeader, sc streamConfig) (io.Reader, error) {
+	contentKey, err := u.unwrapContentKey(h.stanzas)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(contentKey)
+	key, err := deriveStreamKey(contentKey, h.salt)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, key, h, sc)
+}
+
+func (c *Cipher) wrapContentKey(contentKey []byte) (stanza, error) {
+	if err := c.validate(); err != nil {
+		return stanza{}, err
+	}
+	wrapped, err := c.wrapDataKey(contentKey)
+	if err != nil {
+		return stanza{}, err
+	}
+	if c.cfg.derivation == "" {
+		return stanza{stanzaKey, dataKeyHeader(c.keyID, wrapped)}, nil
+	}
+	body := append([]byte{byte(len(c.cfg.derivation))}, c.cfg.derivation...)
+	return stanza{stanzaDerivedKey, append(body, dataKeyHeader(c.keyID, wrapped)...)}, nil
+}
+
+// unwrapContentKey tries every key stanza that c or a Cipher derived from it
+// can unwrap. Derived ciphers share their parent's key ID, so a stanza that
+// fails to authenticate does not end the search.
+func (c *Cipher) unwrapContentKey(stanzas []stanza) ([]byte, error) {
+	err := ErrKeyMismatch
+	for _, s := range stanzas {
+		var path string
+		body := s.body
+		switch s.kind {
+		case stanzaKey:
+		case stanzaDerivedKey:
+			n := int(body[0])
+			if len(body) < 1+n || !validDerivation(body[1:1+n]) {
+				return nil, ErrInvalidEnvelope
+			}
+			path, body = string(body[1:1+n]), body[1+n:]
+		default:
+			continue
+		}
+		id, wrapped, n, ok := parseDataKeyHeader(body)
+		if !ok || n != len(body) {
+			return nil, ErrInvalidEnvelope
+		}
+		if !bytes.Equal(id, c.keyID) {
+			continue
+		}
+		d, derr := c.derived(path)
+		if derr != nil {
+			continue
+		}
+		contentKey, uerr := unwrapHeaderKey(d, id, wrapped)
+		if uerr == nil {
+			return contentKey, nil
+		}
+		err = uerr
+	}
+	return nil, err
+}
+
+func (p *PasswordCipher) wrapContentKey(contentKey []byte) (stanza, error) {
+	if err := p.validate(); err != nil {
+		return stanza{}, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(p.cfg.rand, salt); err != nil {
+		return stanza{}, fmt.Errorf("secure: generate salt: %w", err)
+	}
+	body := passwordParams(p.cfg.argon, salt)
+	kek := argon2.IDKey(p.passphrase, salt, p.cfg.argon.Time, p.cfg.argon.Memory, p.cfg.argon.Threads, keySize)
+	defer clear(kek)
+	wrapped, err := wrapKeyGCM(kek, contentKey, stanzaPassword, p.cfg.rand)
+	if err != nil {
+		return stanza{}, err
+	}
+	return stanza{stanzaPassword, append(body, wrapped...)}, nil
+}
+
+func (p *PasswordCipher) unwrapContentKey(stanzas []stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.kind != stanzaPassword {
+			continue
+		}
+		if len(s.body) < passwordParamsSize {
+			return nil, ErrInvalidEnvelope
+		}
+		params, salt := parsePasswordParams(s.body)
+		if err := validateArgon2(params); err != nil {
+			return nil, err
+		}
+		kek := p.deriveKey(params, salt)
+		defer clear(kek)
+		return unwrapKeyGCM(kek, s.body[passwordParamsSize:], stanzaPassword)
+	}
+	return nil, fmt.Errorf("%w: envelope does not contain password parameters", ErrInvalidEnvelope)
+}
+
+func (r *X25519Recipient) wrapContentKey(contentKey []byte) (stanza, error) {
+	if err := r.validate(); err != nil {
+		return stanza{}, err
+	}
+	ephemeral, kek, err := r.encapsulate()
+	if err != nil {
+		return stanza{}, err
+	}
+	defer clear(kek)
+	wrapped, err := wrapKeyGCM(kek, contentKey, stanzaX25519, r.cfg.rand)
+	if err != nil {
+		return stanza{}, err
+	}
+	return stanza{stanzaX25519, append(ephemeral, wrapped...)}, nil
+}
+
+// unwrapContentKey tries every X25519 stanza, since stanzas do not name
+// their recipient.
+func (i *X25519Identity) unwrapContentKey(stanzas []stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.kind != stanzaX25519 || len(s.body) < x25519KeySize {
+			continue
+		}
+		pub, err := ecdh.X25519().NewPublicKey(s.body[:x25519KeySize])
+		if err != nil {
+			continue
+		}
+		kek, err := x25519Key(i.priv, pub, s.body[:x25519KeySize], i.priv.PublicKey().Bytes())
+		if err != nil {
+			continue
+		}
+		contentKey, err := unwrapKeyGCM(kek, s.body[x25519KeySize:], stanzaX25519)
+		clear(kek)
+		if err == nil {
+			return contentKey, nil
+		}
+	}
+	return nil, ErrAuthentication
+}
+
+// wrapKeyGCM encrypts a content key under a recipient key. The stanza kind is
+// authenticated so a wrapped key cannot be moved to a different stanza type.
+func wrapKeyGCM(kek, contentKey []byte, kind byte, rand io.Reader) ([]byte, error) {
+	aead, err := newAEAD(AES256GCM, kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(contentKey)+aead.Overhead())
+	if _, err := io.ReadFull(rand, nonce); err != nil {
+		return nil, fmt.Errorf("secure: generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, contentKey, stanzaAAD(kind)), nil
+}
+
+func unwrapKeyGCM(kek, wrapped []byte, kind byte) ([]byte, error) {
+	aead, err := newAEAD(AES256GCM, kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) != aead.NonceSize()+keySize+aead.Overhead() {
+		return nil, ErrInvalidEnvelope
+	}
+	contentKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], stanzaAAD(kind))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return contentKey, nil
+}
+
+func stanzaAAD(kind byte) []byte {
+	return append([]byte("github.com/rusq/secure/v2 recipient"), kind)
+}
+
+// parseStanzas decodes a recipient count and its stanzas and reports how many
+// bytes of b they occupy. The count and every stanza are bounded before any
+// allocation.
+func parseStanzas(b []byte) (stanzas []stanza, n int, ok bool) {
+	if len(b) < 1 || b[0] == 0 || b[0] > maxRecipients {
+		return nil, 0, false
+	}
+	count := int(b[0])
+	n = 1
+	stanzas = make([]stanza, 0, count)
+	passwords := 0
+	for range count {
+		if len(b) < n+3 {
+			return nil, 0, false
+		}
+		kind := b[n]
+		size := int(binary.BigEndian.Uint16(b[n+1 : n+3]))
+		if size == 0 || size > maxStanzaSize || len(b) < n+3+size {
+			return nil, 0, false
+		}
+		if kind == stanzaPassword {
+			if passwords++; passwords > maxPasswordRecipients {
+				return nil, 0, false
+			}
+		}
+		stanzas = append(stanzas, stanza{kind, b[n+3 : n+3+size]})
+		n += 3 + size
+	}
+	return stanzas, n, true
+}
+
+// readStanzas reads a recipient count and its stanzas from a stream header and
+// returns them along with their encoded form.
+func readStanzas(r io.Reader) ([]stanza, []byte, error) {
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(r, count); err != nil {
+		return nil, nil, ErrTruncated
+	}
+	if count[0] == 0 || count[0] > maxRecipients {
+		return nil, nil, ErrInvalidEnvelope
+	}
+	raw := count
+	for range int(count[0]) {
+		head := make([]byte, 3)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return nil, nil, ErrTruncated
+		}
+		size := int(binary.BigEndian.Uint16(head[1:]))
+		if size == 0 || size > maxStanzaSize {
+			return nil, nil, ErrInvalidEnvelope
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, nil, ErrTruncated
+		}
+		raw = append(raw, head...)
+		raw = append(raw, body...)
+	}
+	stanzas, _, ok := parseStanzas(raw)
+	if !ok {
+		return nil, nil, ErrInvalidEnvelope
+	}
+	return stanzas, raw, nil
+}
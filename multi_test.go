@@ -0,0 +1,188 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestMultiRecipientEnvelope(t *testing.T) {
+	ops, _ := NewCipher(testKey, WithKeyID([]byte("ops")))
+	escrow, _ := NewPasswordCipher([]byte("escrow passphrase"))
+	app, _ := GenerateX25519Identity()
+	m, err := NewMultiRecipient([]Recipient{ops, escrow, app.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := []byte("backup/2026-10-16")
+	envelope, err := m.Seal([]byte("shared secret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, codec := range map[string]Codec{"cipher": ops, "password": escrow, "x25519": app} {
+		if got, err := codec.Open(envelope, aad); err != nil || string(got) != "shared secret" {
+			t.Fatalf("%s Open() = %q, %v", name, got, err)
+		}
+		if _, err := codec.Open(envelope, []byte("other")); !errors.Is(err, ErrAuthentication) {
+			t.Fatalf("%s wrong AAD error = %v", name, err)
+		}
+	}
+
+	outsider, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize))
+	if _, err := outsider.Open(envelope, aad); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("outsider cipher error = %v", err)
+	}
+	stranger, _ := GenerateX25519Identity()
+	if _, err := stranger.Open(envelope, aad); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("outsider identity error = %v", err)
+	}
+	wrongPassword, _ := NewPasswordCipher([]byte("wrong"))
+	if _, err := wrongPassword.Open(envelope, aad); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong password error = %v", err)
+	}
+
+	ring, _ := NewKeyring(outsider, ops)
+	if got, err := ring.Open(envelope, aad); err != nil || string(got) != "shared secret" {
+		t.Fatalf("Keyring Open() = %q, %v", got, err)
+	}
+	lonely, _ := NewKeyring(outsider)
+	if _, err := lonely.Open(envelope, aad); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("Keyring without recipient error = %v", err)
+	}
+
+	keysOnly, _ := NewMultiRecipient([]Recipient{ops})
+	noPassword, _ := keysOnly.EncryptString("x")
+	if _, err := escrow.DecryptString(noPassword); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("missing password stanza error = %v", err)
+	}
+}
+
+func TestMultiRecipientStream(t *testing.T) {
+	ops, _ := NewCipher(testKey)
+	escrow, _ := NewPasswordCipher([]byte("escrow passphrase"))
+	app, _ := GenerateX25519Identity()
+	m, _ := NewMultiRecipient([]Recipient{ops, escrow, app.Recipient()})
+	input := bytes.Repeat([]byte("dump"), streamChunkSize/2)
+	var encrypted bytes.Buffer
+	w, err := m.NewEncryptWriter(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type decrypter interface {
+		NewDecryptReader(io.Reader, ...StreamOption) (io.Reader, error)
+	}
+	for name, d := range map[string]decrypter{"cipher": ops, "password": escrow, "x25519": app} {
+		r, err := d.NewDecryptReader(bytes.NewReader(encrypted.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+			t.Fatalf("%s ReadAll() = %d bytes, %v", name, len(got), err)
+		}
+	}
+	outsider, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize))
+	if _, err := outsider.NewDecryptReader(bytes.NewReader(encrypted.Bytes())); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("outsider error = %v", err)
+	}
+	if _, err := m.NewEncryptWriter(nil); err == nil {
+		t.Fatal("accepted nil writer")
+	}
+	for _, cut := range []int{len(streamMagic) + 1, len(streamMagic) + 3, len(streamMagic) + 20} {
+		if _, err := ops.NewDecryptReader(bytes.NewReader(encrypted.Bytes()[:cut])); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("cut %d error = %v", cut, err)
+		}
+	}
+}
+
+func TestMultiRecipientLimits(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	p1, _ := NewPasswordCipher([]byte("one"))
+	p2, _ := NewPasswordCipher([]byte("two"))
+	if _, err := NewMultiRecipient(nil); err == nil {
+		t.Fatal("accepted no recipients")
+	}
+	if _, err := NewMultiRecipient([]Recipient{c, nil}); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil recipient error = %v", err)
+	}
+	if _, err := NewMultiRecipient([]Recipient{p1, p2}); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("two passwords error = %v", err)
+	}
+	many := make([]Recipient, maxRecipients+1)
+	for i := range many {
+		many[i] = c
+	}
+	if _, err := NewMultiRecipient(many); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("too many recipients error = %v", err)
+	}
+	if _, err := NewMultiRecipient([]Recipient{c}, nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("nil option error = %v", err)
+	}
+	broken, _ := NewMultiRecipient([]Recipient{new(Cipher)})
+	if _, err := broken.EncryptString("x"); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("unconfigured recipient error = %v", err)
+	}
+	var zero MultiRecipient
+	if _, err := zero.Seal(nil, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("zero Seal error = %v", err)
+	}
+	if _, err := zero.NewEncryptWriter(io.Discard); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("zero writer error = %v", err)
+	}
+
+	stanza := func(kind byte, size int) []byte {
+		b := []byte{kind}
+		b = binary.BigEndian.AppendUint16(b, uint16(size))
+		return append(b, make([]byte, size)...)
+	}
+	password := stanza(stanzaPassword, 85)
+	hostile := [][]byte{
+		{0},
+		{maxRecipients + 1},
+		{255},
+		append([]byte{1}, stanza(stanzaKey, 0)...),
+		append([]byte{1}, stanza(stanzaKey, maxStanzaSize+1)...),
+		append([]byte{2}, stanza(stanzaKey, 8)...),
+		append(append([]byte{2}, password...), password...),
+	}
+	for _, h := range hostile {
+		envelope := prefix + base64.RawURLEncoding.EncodeToString(append(append([]byte{envelopeVersion, modeMulti}, h...), make([]byte, 28)...))
+		if _, err := c.Open(envelope, nil); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("envelope header %x error = %v", h[:min(len(h), 8)], err)
+		}
+		stream := append(append([]byte(streamMagic), modeMulti), h...)
+		stream = append(stream, make([]byte, saltSize+64)...)
+		if _, err := c.NewDecryptReader(bytes.NewReader(stream)); !errors.Is(err, ErrInvalidEnvelope) && !errors.Is(err, ErrTruncated) {
+			t.Fatalf("stream header %x error = %v", h[:min(len(h), 8)], err)
+		}
+	}
+	malformedKey := append([]byte{1}, stanza(stanzaKey, 8)...)
+	envelope := prefix + base64.RawURLEncoding.EncodeToString(append(append([]byte{envelopeVersion, modeMulti}, malformedKey...), make([]byte, 28)...))
+	if _, err := c.Open(envelope, nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("malformed key stanza error = %v", err)
+	}
+	malformedDerived := append([]byte{1}, stanza(stanzaDerivedKey, 8)...)
+	envelope = prefix + base64.RawURLEncoding.EncodeToString(append(append([]byte{envelopeVersion, modeMulti}, malformedDerived...), make([]byte, 28)...))
+	if _, err := c.Open(envelope, nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("malformed derived key stanza error = %v", err)
+	}
+	shortPassword := append([]byte{1}, stanza(stanzaPassword, 8)...)
+	envelope = prefix + base64.RawURLEncoding.EncodeToString(append(append([]byte{envelopeVersion, modeMulti}, shortPassword...), make([]byte, 28)...))
+	if _, err := p1.Open(envelope, nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("short password stanza error = %v", err)
+	}
+	weakPassword := append([]byte{1}, password...)
+	envelope = prefix + base64.RawURLEncoding.EncodeToString(append(append([]byte{envelopeVersion, modeMulti}, weakPassword...), make([]byte, 28)...))
+	if _, err := p1.Open(envelope, nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("weak password stanza error = %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package secure
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// DecryptReaderAt decrypts any part of a stream without reading the records
+// before it, for example to serve HTTP range requests for encrypted media.
+// Every read authenticates the records it touches. The final record is
+// checked when the reader is created, so a truncated or extended stream is
+// rejected before any plaintext is returned.
+//
+// ReadAt may be called concurrently. Read and Seek share a position and, like
+// io.SectionReader, must not be used concurrently.
+type DecryptReaderAt struct {
+	r       io.ReaderAt
+	aead    cipher.AEAD
+	header  []byte
+	offset  int64  // of the first data record
+	base    uint64 // counter of the first data record
+	chunk   int64  // plaintext bytes in every record but the last
+	records int64  // data records, without the trailer and final record
+	size    int64  // plaintext bytes
+	pos     int64
+
+	metadata *StreamMetadata
+	trailer  *StreamTrailer
+
+	mu     sync.Mutex
+	cached int64 // index of the record in plaintext, or -1
+	plain  []byte
+}
+
+// NewDecryptReaderAt returns a random-access reader for the key-based stream
+// of size bytes in r. It accepts the options of NewDecryptReader;
+// WithStreamWorkers has no effect.
+func (c *Cipher) NewDecryptReaderAt(r io.ReaderAt, size int64, opts ...StreamOption) (*DecryptReaderAt, error) {
+	return newDecryptReaderAt(c, r, size, opts)
+}
+
+// NewDecryptReaderAt returns a random-access reader for the password stream
+// of size bytes in r.
+func (p *PasswordCipher) NewDecryptReaderAt(r io.ReaderAt, size int64, opts ...StreamOption) (*DecryptReaderAt, error) {
+	return newDecryptReaderAt(p, r, size, opts)
+}
+
+// NewDecryptReaderAt returns a random-access reader for the stream of size
+// bytes in r sealed to the identity.
+func (i *X25519Identity) NewDecryptReaderAt(r io.ReaderAt, size int64, opts ...StreamOption) (*DecryptReaderAt, error) {
+	return newDecryptReaderAt(i, r, size, opts)
+}
+
+// NewDecryptReaderAt returns a random-access reader for the hybrid, X25519,
+// or multi-recipient stream of size bytes in r.
+func (i *HybridIdentity) NewDecryptReaderAt(r io.ReaderAt, size int64, opts ...StreamOption) (*DecryptReaderAt, error) {
+	return newDecryptReaderAt(i, r, size, opts)
+}
+
+// NewDecryptReaderAt returns a random-access reader for the data key stream
+// of size bytes in r.
+func (e *EnvelopeCipher) NewDecryptReaderAt(r io.ReaderAt, size int64, opts ...StreamOption) (*DecryptReaderAt, error) {
+	return newDecryptReaderAt(e, r, size, opts)
+}
+
+type streamOpener interface {
+	NewDecryptReader(r io.Reader, opts ...StreamOption) (io.Reader, error)
+}
+
+func newDecryptReaderAt(o streamOpener, r io.ReaderAt, size int64, opts []StreamOption) (*DecryptReaderAt, error) {
+	if r == nil {
+		return nil, errors.New("secure: nil reader")
+	}
+	if size < 0 {
+		return nil, errors.New("secure: negative stream size")
+	}
+	// The opener reads exactly the header and unwraps the stream key.
+	header := &countingReader{r: io.NewSectionReader(r, 0, size)}
+	sequential, err := o.NewDecryptReader(header, opts...)
+	if err != nil {
+		return nil, err
+	}
+	dr, ok := sequential.(*decryptReader)
+	if !ok {
+		return nil, ErrInvalidEnvelope
+	}
+	// The metadata record, if any, has been read with the header.
+	d := &DecryptReaderAt{r: r, aead: dr.aead, header: dr.header, offset: header.n, base: dr.counter, chunk: int64(dr.chunk), metadata: dr.metadata, cached: -1}
+	recordSize, finalSize := d.recordSize(d.chunk), d.recordSize(0)
+	tailSize := finalSize
+	if dr.records&streamTrailer != 0 {
+		d.trailer = &StreamTrailer{}
+		tailSize += d.recordSize(streamTrailerSize)
+	}
+	body := size - d.offset
+	if body < tailSize {
+		return nil, ErrTruncated
+	}
+	full, rest := (body-tailSize)/recordSize, (body-tailSize)%recordSize
+	if rest != 0 && rest <= finalSize {
+		return nil, ErrInvalidEnvelope
+	}
+	d.records, d.size = full, full*d.chunk
+	if rest != 0 {
+		d.records++
+		d.size += rest - finalSize
+	}
+	if d.trailer != nil {
+		plaintext, err := d.readRecord(d.records)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := decodeStreamTrailer(plaintext)
+		if !ok || t.Size != d.size {
+			return nil, ErrInvalidEnvelope
+		}
+		d.trailer = &t
+	}
+	if _, err := d.readRecord(d.lastRecord()); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Size returns the length of the plaintext.
+func (d *DecryptReaderAt) Size() int64 { return d.size }
+
+// ReadAt decrypts len(p) bytes of plaintext starting at off.
+func (d *DecryptReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("secure: negative offset")
+	}
+	n := 0
+	for n < len(p) && off < d.size {
+		plaintext, err := d.record(off / d.chunk)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], plaintext[off%d.chunk:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read decrypts plaintext from the current position.
+func (d *DecryptReaderAt) Read(p []byte) (int, error) {
+	n, err := d.ReadAt(p, d.pos)
+	d.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the position of the next Read, like io.Seeker.
+func (d *DecryptReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("secure: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("secure: negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+// record returns the plaintext of data record i, keeping the last one for
+// sequential reads.
+func (d *DecryptReaderAt) record(i int64) ([]byte, error) {
+	d.mu.Lock()
+	if d.cached == i {
+		plaintext := d.plain
+		d.mu.Unlock()
+		return plaintext, nil
+	}
+	d.mu.Unlock()
+	plaintext, err := d.readRecord(i)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.cached, d.plain = i, plaintext
+	d.mu.Unlock()
+	return plaintext, nil
+}
+
+// lastRecord returns the index of the final record.
+func (d *DecryptReaderAt) lastRecord() int64 {
+	if d.trailer != nil {
+		return d.records + 1
+	}
+	return d.records
+}
+
+// readRecord reads and authenticates data record i, or the trailer and final
+// record that follow the data records. Every record must have the length the
+// writer gives it, so that offsets follow from the stream size.
+func (d *DecryptReaderAt) readRecord(i int64) ([]byte, error) {
+	length, flags := d.chunk, byte(0)
+	switch {
+	case i == d.lastRecord():
+		length, flags = 0, streamFinal
+	case i == d.records:
+		length, flags = streamTrailerSize, streamTrailer
+	case i == d.records-1:
+		length = d.size - i*d.chunk
+	}
+	// Only the last data record may be short, and the trailer and final
+	// record follow it.
+	offset := d.offset + i*d.recordSize(d.chunk)
+	if i >= d.records {
+		offset = d.offset + d.size + d.records*d.recordSize(0)
+		if i > d.records {
+			offset += d.recordSize(streamTrailerSize)
+		}
+	}
+	record := make([]byte, d.recordSize(length))
+	if n, _ := d.r.ReadAt(record, offset); n < len(record) {
+		return nil, ErrTruncated
+	}
+	recordHeader, ciphertext := record[:5], record[5:]
+	if int64(binary.BigEndian.Uint32(recordHeader[:4])) != length || recordHeader[4] != flags {
+		return nil, ErrInvalidEnvelope
+	}
+	counter := d.base + uint64(i)
+	plaintext, err := d.aead.Open(nil, streamNonce(d.aead.NonceSize(), counter), ciphertext, streamAAD(d.header, counter, recordHeader))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
+
+// recordSize returns the encoded size of a record holding length bytes.
+func (d *DecryptReaderAt) recordSize(length int64) int64 {
+	return 5 + length + int64(d.aead.Overhead())
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,191 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func encryptStream(t *testing.T, w interface {
+	NewEncryptWriter(io.Writer, ...StreamOption) (io.WriteCloser, error)
+}, plaintext []byte, opts ...StreamOption) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := w.NewEncryptWriter(&buf, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecryptReaderAt(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3*streamChunkSize + 17} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 7)
+		}
+		stream := encryptStream(t, c, plaintext)
+		r, err := c.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if r.Size() != int64(size) {
+			t.Fatalf("Size() = %d, want %d", r.Size(), size)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: ReadAll() = %d bytes, %v", size, len(got), err)
+		}
+		for _, span := range [][2]int{{0, size}, {size / 2, size}, {size / 3, 2 * size / 3}, {max(size-10, 0), size}} {
+			got := make([]byte, span[1]-span[0])
+			if n, err := r.ReadAt(got, int64(span[0])); err != nil || n != len(got) || !bytes.Equal(got, plaintext[span[0]:span[1]]) {
+				t.Fatalf("size %d: ReadAt(%v) = %d, %v", size, span, n, err)
+			}
+		}
+		if n, err := r.ReadAt(make([]byte, 8), int64(size)); n != 0 || err != io.EOF {
+			t.Fatalf("ReadAt(end) = %d, %v", n, err)
+		}
+	}
+}
+
+func TestDecryptReaderAtSeek(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	plaintext := bytes.Repeat([]byte("0123456789"), streamChunkSize/5)
+	stream := encryptStream(t, c, plaintext)
+	r, err := c.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos, err := r.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(plaintext)-10) {
+		t.Fatalf("Seek(end) = %d, %v", pos, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "0123456789" {
+		t.Fatalf("read after Seek = %q", got)
+	}
+	r.Seek(streamChunkSize-3, io.SeekStart)
+	r.Seek(1, io.SeekCurrent)
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(r, got); err != nil || !bytes.Equal(got, plaintext[streamChunkSize-2:streamChunkSize+2]) {
+		t.Fatalf("read across records = %q, %v", got, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("Seek to a negative position succeeded")
+	}
+	if _, err := r.Seek(0, 3); err == nil {
+		t.Fatal("Seek with invalid whence succeeded")
+	}
+	if _, err := r.ReadAt(got, -1); err == nil {
+		t.Fatal("ReadAt at a negative offset succeeded")
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			off := i * len(plaintext) / 8
+			buf := make([]byte, 1000)
+			if _, err := r.ReadAt(buf, int64(off)); err != nil || !bytes.Equal(buf, plaintext[off:off+1000]) {
+				t.Errorf("concurrent ReadAt(%d) = %v", off, err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestDecryptReaderAtRejectsModifiedStreams(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	plaintext := bytes.Repeat([]byte{1}, 2*streamChunkSize+100)
+	stream := encryptStream(t, c, plaintext)
+	open := func(b []byte) (*DecryptReaderAt, error) {
+		return c.NewDecryptReaderAt(bytes.NewReader(b), int64(len(b)))
+	}
+	finalSize := 5 + tagSize
+	for name, tt := range map[string]struct {
+		stream []byte
+		want   error
+	}{
+		"no final record": {stream[:len(stream)-finalSize], ErrInvalidEnvelope},
+		"truncated":       {stream[:len(stream)-1], ErrInvalidEnvelope},
+		"extended":        {append(bytes.Clone(stream), make([]byte, streamChunkSize+finalSize+1)...), ErrInvalidEnvelope},
+		"header only":     {stream[:30], ErrTruncated},
+	} {
+		if _, err := open(tt.stream); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: error = %v, want %v", name, err, tt.want)
+		}
+	}
+	if _, err := c.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream))+1); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("wrong size error = %v", err)
+	}
+	if _, err := c.NewDecryptReaderAt(nil, 0); err == nil {
+		t.Fatal("nil reader succeeded")
+	}
+	if _, err := c.NewDecryptReaderAt(bytes.NewReader(stream), -1); err == nil {
+		t.Fatal("negative size succeeded")
+	}
+
+	// Only reads of a modified record fail.
+	modified := bytes.Clone(stream)
+	modified[len(modified)-finalSize-50] ^= 1
+	r, err := open(modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(make([]byte, 100), 0); err != nil {
+		t.Fatalf("ReadAt(unmodified record) error = %v", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 100), 2*streamChunkSize); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("ReadAt(modified record) error = %v", err)
+	}
+	other, _ := NewCipher(bytes.Repeat([]byte{9}, keySize))
+	if _, err := other.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream))); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong key error = %v", err)
+	}
+}
+
+func TestDecryptReaderAtOpeners(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("payload"), 20000)
+	check := func(name string, r *DecryptReaderAt, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := make([]byte, 100)
+		if _, err := r.ReadAt(got, 100000); err != nil || !bytes.Equal(got, plaintext[100000:100100]) {
+			t.Fatalf("%s: ReadAt() = %v", name, err)
+		}
+	}
+	p, _ := NewPasswordCipher([]byte("password"))
+	stream := encryptStream(t, p, plaintext)
+	r, err := p.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+	check("password", r, err)
+
+	identity, _ := GenerateX25519Identity()
+	stream = encryptStream(t, identity.Recipient(), plaintext)
+	r, err = identity.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+	check("x25519", r, err)
+
+	hybrid, _ := GenerateHybridIdentity()
+	stream = encryptStream(t, hybrid.Recipient(), plaintext)
+	r, err = hybrid.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+	check("hybrid", r, err)
+
+	kek, _ := NewCipher(testKey, WithKeyID([]byte("kek")))
+	e, _ := NewEnvelopeCipher(kek)
+	stream = encryptStream(t, e, plaintext)
+	r, err = e.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+	check("envelope", r, err)
+
+	// A multi-recipient stream opened by a derived child's parent.
+	tenant, _ := kek.Derive([]byte("tenant"))
+	m, _ := NewMultiRecipient([]Recipient{tenant, identity.Recipient()})
+	stream = encryptStream(t, m, plaintext)
+	r, err = tenant.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+	check("multi", r, err)
+}
@@ -0,0 +1,58 @@
+package secure
+
+// NeedsRehash reports whether a password envelope was sealed with settings
+// weaker or older than p's: an Argon2id parameter below p's, no key
+// commitment while p commits, or a different algorithm. It reads only the
+// header, so it neither derives a key nor authenticates the envelope. It
+// returns false for values that are not password envelopes, including
+// envelopes for multiple recipients, which p cannot reseal without dropping
+// the other recipients.
+func (p *PasswordCipher) NeedsRehash(envelope string) bool {
+	if p.validate() != nil {
+		return false
+	}
+	env, err := parseEnvelope(envelope, p.cfg.maxEnvelope)
+	return err == nil && p.needsRehash(env)
+}
+
+func (p *PasswordCipher) needsRehash(env envelope) bool {
+	if !isPasswordEnvelope(env) {
+		return false
+	}
+	params, _ := parsePasswordParams(env.fields)
+	current := p.cfg.argon
+	return params.Time < current.Time || params.Memory < current.Memory || params.Threads < current.Threads ||
+		p.cfg.commit && !env.ext.committed || env.ext.alg != p.cfg.alg
+}
+
+// OpenAndUpgrade opens a password envelope like Open and, if NeedsRehash
+// reports true for it, also reseals the plaintext with p's current settings.
+// The upgraded envelope keeps the metadata and times of the original, so an
+// upgrade never extends an expiry. upgraded is empty when the envelope is
+// already current; otherwise callers should store it in place of envelope.
+func (p *PasswordCipher) OpenAndUpgrade(envelope string, additionalData []byte) (plaintext []byte, upgraded string, err error) {
+	if err := p.validate(); err != nil {
+		return nil, "", err
+	}
+	if len(additionalData) > p.cfg.maxEnvelope {
+		return nil, "", ErrLimitExceeded
+	}
+	env, err := parseEnvelope(envelope, p.cfg.maxEnvelope)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext, err = p.open(env, additionalData)
+	if plaintext, err = p.cfg.checkExpiry(env, plaintext, err); err != nil {
+		return nil, "", err
+	}
+	if !p.needsRehash(env) {
+		return plaintext, "", nil
+	}
+	cfg := p.cfg.config
+	cfg.metadata, cfg.times = env.ext.metadata, &env.ext
+	if upgraded, err = p.seal(plaintext, additionalData, cfg); err != nil {
+		clear(plaintext)
+		return nil, "", err
+	}
+	return plaintext, upgraded, nil
+}
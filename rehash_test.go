@@ -0,0 +1,88 @@
+package secure
+
+import (
+	"errors"
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestOpenAndUpgrade(t *testing.T) {
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	old, _ := NewPasswordCipher([]byte("password"), WithPasswordExpiry(time.Hour), WithPasswordClock(clock.now))
+	envelope, _ := old.SealWithMetadata([]byte("secret"), []byte("aad"), map[string]string{"owner": "alice"})
+	if old.NeedsRehash(envelope) {
+		t.Fatal("current envelope needs rehash")
+	}
+	if got, upgraded, err := old.OpenAndUpgrade(envelope, []byte("aad")); err != nil || string(got) != "secret" || upgraded != "" {
+		t.Fatalf("OpenAndUpgrade(current) = %q, %q, %v", got, upgraded, err)
+	}
+
+	stronger := Argon2Parameters{Time: defaultArgonTime + 1, Memory: defaultArgonMemory, Threads: defaultArgonThreads}
+	clock.t = clock.t.Add(time.Minute)
+	p, _ := NewPasswordCipher([]byte("password"), WithArgon2Parameters(stronger), WithPasswordClock(clock.now))
+	if !p.NeedsRehash(envelope) {
+		t.Fatal("weaker envelope does not need rehash")
+	}
+	got, upgraded, err := p.OpenAndUpgrade(envelope, []byte("aad"))
+	if err != nil || string(got) != "secret" || upgraded == "" {
+		t.Fatalf("OpenAndUpgrade(weaker) = %q, %q, %v", got, upgraded, err)
+	}
+	if p.NeedsRehash(upgraded) {
+		t.Fatal("upgraded envelope needs rehash")
+	}
+	before, _ := Inspect(envelope)
+	after, _ := Inspect(upgraded)
+	if after.Argon2 != stronger || !after.NotAfter.Equal(before.NotAfter) || !maps.Equal(after.Metadata, before.Metadata) {
+		t.Fatalf("upgraded envelope = %+v, want times and metadata of %+v", after, before)
+	}
+	if got, err := p.Open(upgraded, []byte("aad")); err != nil || string(got) != "secret" {
+		t.Fatalf("Open(upgraded) = %q, %v", got, err)
+	}
+	if _, _, err := p.OpenAndUpgrade(envelope, nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+	clock.t = clock.t.Add(2 * time.Hour)
+	if _, upgraded, err := p.OpenAndUpgrade(envelope, []byte("aad")); !errors.Is(err, ErrExpired) || upgraded != "" {
+		t.Fatalf("OpenAndUpgrade(expired) = %q, %v", upgraded, err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	p, _ := NewPasswordCipher([]byte("correct horse battery staple"))
+	if !p.NeedsRehash(legacyPasswordEnvelope) {
+		t.Fatal("envelope without key commitment does not need rehash")
+	}
+	got, upgraded, err := p.OpenAndUpgrade(legacyPasswordEnvelope, []byte("v2.0"))
+	if err != nil || string(got) != "sealed before key commitment" || p.NeedsRehash(upgraded) {
+		t.Fatalf("OpenAndUpgrade(legacy) = %q, %q, %v", got, upgraded, err)
+	}
+	if info, _ := Inspect(upgraded); !info.Committed {
+		t.Fatal("upgraded envelope is not committed")
+	}
+
+	x, _ := NewPasswordCipher([]byte("correct horse battery staple"), WithPasswordAlgorithm(XChaCha20Poly1305))
+	if !x.NeedsRehash(upgraded) {
+		t.Fatal("envelope with another algorithm does not need rehash")
+	}
+	m, _ := NewMultiRecipient([]Recipient{p})
+	multi, _ := m.Seal([]byte("secret"), nil)
+	if p.NeedsRehash(multi) {
+		t.Fatal("multi-recipient envelope needs rehash")
+	}
+	if _, upgraded, err := p.OpenAndUpgrade(multi, nil); err != nil || upgraded != "" {
+		t.Fatalf("OpenAndUpgrade(multi) = %q, %v", upgraded, err)
+	}
+	c, _ := NewCipher(testKey)
+	keyed, _ := c.EncryptString("secret")
+	var unconfigured *PasswordCipher
+	if p.NeedsRehash(keyed) || p.NeedsRehash("plain") || unconfigured.NeedsRehash(upgraded) {
+		t.Fatal("NeedsRehash reported true for a value it cannot upgrade")
+	}
+	if _, _, err := unconfigured.OpenAndUpgrade(upgraded, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil OpenAndUpgrade error = %v", err)
+	}
+	if _, _, err := p.OpenAndUpgrade("plain", nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("OpenAndUpgrade(plain) error = %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package secure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// resealer is implemented by codecs that can seal a rewrapped envelope with
+// the times and metadata recorded in the original.
+type resealer interface {
+	reseal(plaintext, additionalData []byte, x extensions) (string, error)
+}
+
+// Rewrap opens envelope with from and seals its contents with to, binding the
+// same additional data. Use it to move an envelope to a new key, new Argon2id
+// parameters, or between key and password modes. The new envelope keeps the
+// metadata and the issued-at and not-after times of the original, so a
+// rewrap never extends an expiry. Rewrap returns an error instead if to
+// cannot record them, as with an X25519Identity or a DeterministicCipher.
+// The plaintext is never returned and is zeroed before Rewrap returns; copies
+// made by the codecs or the runtime are outside its control.
+func Rewrap(envelope string, from, to Codec, additionalData []byte) (string, error) {
+	if from == nil || to == nil {
+		return "", ErrUnconfigured
+	}
+	plaintext, err := from.Open(envelope, additionalData)
+	if err != nil {
+		return "", err
+	}
+	defer clear(plaintext)
+	// from has authenticated the header. Legacy envelopes have no times or
+	// metadata.
+	var x extensions
+	if env, err := parseEnvelope(envelope, len(envelope)); err == nil {
+		x = env.ext
+	}
+	if r, ok := to.(resealer); ok {
+		return r.reseal(plaintext, additionalData, x)
+	}
+	if x.hasIssuedAt || x.hasNotAfter {
+		return "", errors.New("secure: rewrap target cannot record envelope times")
+	}
+	if x.metadata != "" {
+		return "", errors.New("secure: rewrap target cannot record envelope metadata")
+	}
+	return to.Seal(plaintext, additionalData)
+}
+
+// RewrapAll rewraps every envelope with the same codecs and additional data.
+// It stops at the first failure and returns no envelopes in that case, so a
+// partially rotated batch is never persisted by mistake.
+func RewrapAll(envelopes []string, from, to Codec, additionalData []byte) ([]string, error) {
+	if from == nil || to == nil {
+		return nil, ErrUnconfigured
+	}
+	out := make([]string, len(envelopes))
+	for i, envelope := range envelopes {
+		rewrapped, err := Rewrap(envelope, from, to, additionalData)
+		if err != nil {
+			return nil, fmt.Errorf("secure: rewrap envelope %d: %w", i, err)
+		}
+		out[i] = rewrapped
+	}
+	return out, nil
+}
+
+// resealConfig returns c with the metadata of x and, if x has any, its
+// times in place of those c would record.
+func (c config) resealConfig(x extensions) config {
+	c.metadata = x.metadata
+	if x.hasIssuedAt || x.hasNotAfter {
+		c.times = &x
+	}
+	return c
+}
+
+func (c *Cipher) reseal(plaintext, additionalData []byte, x extensions) (string, error) {
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+	cfg := c.cfg.resealConfig(x)
+	return c.seal(plaintext, additionalData, cfg, cfg.embedKeyID)
+}
+
+func (p *PasswordCipher) reseal(plaintext, additionalData []byte, x extensions) (string, error) {
+	if err := p.validate(); err != nil {
+		return "", err
+	}
+	return p.seal(plaintext, additionalData, p.cfg.resealConfig(x))
+}
+
+func (k *Keyring) reseal(plaintext, additionalData []byte, x extensions) (string, error) {
+	if err := k.validate(); err != nil {
+		return "", err
+	}
+	k.mu.RLock()
+	primary := k.primary
+	k.mu.RUnlock()
+	return primary.seal(plaintext, additionalData, primary.cfg.resealConfig(x), true)
+}
+
+func (e *EnvelopeCipher) reseal(plaintext, additionalData []byte, x extensions) (string, error) {
+	if err := e.validate(); err != nil {
+		return "", err
+	}
+	return sealDataKey(e.wrapper, plaintext, additionalData, e.cfg.resealConfig(x))
+}
@@ -0,0 +1,159 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+)
+
+type recordingCodec struct {
+	Codec
+	sealed [][]byte
+}
+
+func (c *recordingCodec) Seal(plaintext, additionalData []byte) (string, error) {
+	c.sealed = append(c.sealed, plaintext)
+	return c.Codec.Seal(plaintext, additionalData)
+}
+
+func TestRewrapBetweenModes(t *testing.T) {
+	oldKey, _ := NewCipher(testKey)
+	newKey, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize), WithKeyID([]byte("new")))
+	p, _ := NewPasswordCipher([]byte("password"))
+	aad := []byte("record:7")
+
+	envelope, err := oldKey.Seal([]byte("rotate me"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toPassword, err := Rewrap(envelope, oldKey, p, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toKey, err := Rewrap(toPassword, p, newKey, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := newKey.Open(toKey, aad); err != nil || string(got) != "rotate me" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	if _, err := oldKey.Open(toKey, aad); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("old key error = %v", err)
+	}
+	if _, err := Rewrap(envelope, oldKey, newKey, []byte("wrong")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+	if _, err := Rewrap(envelope, nil, newKey, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil codec error = %v", err)
+	}
+}
+
+func TestRewrapZeroesPlaintext(t *testing.T) {
+	oldKey, _ := NewCipher(testKey)
+	newKey, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize))
+	envelope, _ := oldKey.EncryptString("sensitive")
+	recorder := &recordingCodec{Codec: newKey}
+	if _, err := Rewrap(envelope, oldKey, recorder, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.sealed) != 1 || !bytes.Equal(recorder.sealed[0], make([]byte, len("sensitive"))) {
+		t.Fatalf("plaintext not zeroed: %q", recorder.sealed)
+	}
+}
+
+func TestRewrapAll(t *testing.T) {
+	oldKey, _ := NewCipher(testKey)
+	newKey, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize))
+	var envelopes []string
+	for _, value := range []string{"a", "b", "c"} {
+		envelope, _ := oldKey.EncryptString(value)
+		envelopes = append(envelopes, envelope)
+	}
+	rewrapped, err := RewrapAll(envelopes, oldKey, newKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"a", "b", "c"} {
+		if got, err := newKey.DecryptString(rewrapped[i]); err != nil || got != want {
+			t.Fatalf("envelope %d = %q, %v", i, got, err)
+		}
+	}
+
+	envelopes[1] = "plain"
+	out, err := RewrapAll(envelopes, oldKey, newKey, nil)
+	if !errors.Is(err, ErrInvalidEnvelope) || out != nil {
+		t.Fatalf("RewrapAll() = %q, %v", out, err)
+	}
+	if _, err := RewrapAll(envelopes, oldKey, nil, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil codec error = %v", err)
+	}
+}
+
+func TestRewrapKeepsTimes(t *testing.T) {
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	oldKey, _ := NewCipher(testKey, WithExpiry(time.Minute), WithClock(clock.now))
+	newKey, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize), WithClock(clock.now))
+	p, _ := NewPasswordCipher([]byte("password"), WithPasswordClock(clock.now))
+	envelope, err := oldKey.Seal([]byte("reset token"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toKey, err := Rewrap(envelope, oldKey, newKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toPassword, err := Rewrap(toKey, newKey, p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := Inspect(toPassword); err != nil || !info.NotAfter.Equal(clock.t.Add(time.Minute)) {
+		t.Fatalf("Inspect() = %+v, %v", info, err)
+	}
+
+	clock.t = clock.t.Add(time.Hour)
+	if _, err := newKey.Open(toKey, nil); !errors.Is(err, ErrExpired) {
+		t.Fatalf("rewrapped key envelope error = %v", err)
+	}
+	if _, err := p.Open(toPassword, nil); !errors.Is(err, ErrExpired) {
+		t.Fatalf("rewrapped password envelope error = %v", err)
+	}
+
+	// Codecs that cannot record the times refuse to drop them.
+	clock.t = clock.t.Add(-time.Hour)
+	identity, _ := GenerateX25519Identity()
+	if _, err := Rewrap(envelope, oldKey, identity, nil); err == nil {
+		t.Fatal("Rewrap() to a recipient dropped the expiry")
+	}
+	plain, _ := newKey.Seal([]byte("no expiry"), nil)
+	if _, err := Rewrap(plain, newKey, identity, nil); err != nil {
+		t.Fatalf("Rewrap() without times = %v", err)
+	}
+}
+
+func TestRewrapKeepsMetadata(t *testing.T) {
+	oldKey, _ := NewCipher(testKey)
+	newKey, _ := NewCipher(bytes.Repeat([]byte{0x17}, keySize))
+	ring, _ := NewKeyring(newKey)
+	metadata := map[string]string{"table": "users", "column": "email"}
+	envelope, err := oldKey.SealWithMetadata([]byte("a@example.com"), nil, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := Rewrap(envelope, oldKey, ring, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := EnvelopeMetadata(rewrapped); err != nil || !maps.Equal(got, metadata) {
+		t.Fatalf("EnvelopeMetadata() = %v, %v", got, err)
+	}
+	if _, got, err := ring.OpenWithMetadata(rewrapped, nil); err != nil || !maps.Equal(got, metadata) {
+		t.Fatalf("OpenWithMetadata() = %v, %v", got, err)
+	}
+
+	identity, _ := GenerateX25519Identity()
+	if _, err := Rewrap(envelope, oldKey, identity, nil); err == nil {
+		t.Fatal("Rewrap() to a recipient dropped the metadata")
+	}
+}
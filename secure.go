@@ -3,7 +3,7 @@
 package secure
 
 import (
-	"crypto/aes"
+	"bytes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
@@ -11,18 +11,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"golang.org/x/crypto/argon2"
 )
 
 const (
-	prefix             = "SEC2."
-	envelopeVersion    = 2
-	modeKey            = 1
-	modePassword       = 2
-	keySize            = 32
-	saltSize           = 16
-	defaultMaxEnvelope = 16 << 20
+	prefix            = "SEC2."
+	envelopeVersion   = 2
+	modeKey           = 1
+	modePassword      = 2
+	modeKeyID         = 3
+	modeDataKey       = 4
+	modeX25519        = 5
+	modeMulti         = 6
+	modeHybrid        = 7
+	modeDeterministic = 8
+	// modePasswordSession envelopes are sealed by a PasswordSession.
+	modePasswordSession = 9
+	keySize             = 32
+	saltSize            = 16
+	tagSize             = 16
+	passwordParamsSize  = 4 + 4 + 1 + saltSize
+	defaultMaxEnvelope  = 16 << 20
 
 	defaultArgonTime    = uint32(3)
 	defaultArgonMemory  = uint32(64 * 1024)
@@ -41,6 +52,18 @@ type Codec interface {
 type config struct {
 	maxEnvelope int
 	rand        io.Reader
+	keyID       []byte
+	embedKeyID  bool
+	dataKeys    bool
+	alg         Algorithm
+	commit      bool
+	derivation  string
+	issuedAt    bool
+	ttl         time.Duration
+	clock       func() time.Time
+	metadata    string
+	// times, if set, replaces the times recorded by issuedAt and ttl.
+	times *extensions
 }
 
 // Option configures a Cipher.
@@ -58,7 +81,7 @@ func WithMaxEnvelopeSize(n int) Option {
 }
 
 func newConfig(opts []Option) (config, error) {
-	c := config{maxEnvelope: defaultMaxEnvelope, rand: rand.Reader}
+	c := config{maxEnvelope: defaultMaxEnvelope, rand: rand.Reader, alg: AES256GCM}
 	for _, opt := range opts {
 		if opt == nil {
 			return config{}, fmt.Errorf("%w: nil option", ErrInvalidEnvelope)
@@ -70,10 +93,12 @@ func newConfig(opts []Option) (config, error) {
 	return c, nil
 }
 
-// Cipher is an immutable AES-256-GCM encryption context.
+// Cipher is an immutable encryption context. It seals with AES-256-GCM
+// unless another Algorithm is selected.
 type Cipher struct {
-	key [keySize]byte
-	cfg config
+	key   [keySize]byte
+	keyID []byte
+	cfg   config
 }
 
 func (c *Cipher) validate() error {
@@ -92,8 +117,13 @@ func NewCipher(key []byte, opts ...Option) (*Cipher, error) {
 	if err != nil {
 		return nil, err
 	}
-	c := &Cipher{cfg: cfg}
+	c := &Cipher{cfg: cfg, keyID: cfg.keyID}
 	copy(c.key[:], key)
+	if c.keyID == nil {
+		if c.keyID, err = deriveKeyID(c.key[:]); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
@@ -120,7 +150,9 @@ func validateArgon2(p Argon2Parameters) error {
 
 type passwordConfig struct {
 	config
-	argon Argon2Parameters
+	argon         Argon2Parameters
+	cacheEntries  int
+	requireCommit bool
 }
 
 // PasswordOption configures a PasswordCipher.
@@ -148,6 +180,7 @@ func WithArgon2Parameters(p Argon2Parameters) PasswordOption {
 type PasswordCipher struct {
 	passphrase []byte
 	cfg        passwordConfig
+	cache      *keyCache
 }
 
 func (p *PasswordCipher) validate() error {
@@ -162,7 +195,7 @@ func NewPasswordCipher(passphrase []byte, opts ...PasswordOption) (*PasswordCiph
 	if len(passphrase) == 0 {
 		return nil, errors.New("secure: empty passphrase")
 	}
-	cfg := passwordConfig{config: config{maxEnvelope: defaultMaxEnvelope, rand: rand.Reader}, argon: defaultArgon2Parameters()}
+	cfg := passwordConfig{config: config{maxEnvelope: defaultMaxEnvelope, rand: rand.Reader, alg: AES256GCM, commit: true}, argon: defaultArgon2Parameters()}
 	for _, opt := range opts {
 		if opt == nil {
 			return nil, fmt.Errorf("%w: nil option", ErrInvalidEnvelope)
@@ -171,33 +204,91 @@ func NewPasswordCipher(passphrase []byte, opts ...PasswordOption) (*PasswordCiph
 			return nil, err
 		}
 	}
-	return &PasswordCipher{passphrase: append([]byte(nil), passphrase...), cfg: cfg}, nil
+	p := &PasswordCipher{passphrase: append([]byte(nil), passphrase...), cfg: cfg}
+	if cfg.cacheEntries > 0 {
+		p.cache = newKeyCache(cfg.cacheEntries)
+	}
+	return p, nil
 }
 
 // Seal encrypts plaintext and authenticates additionalData without storing it.
 func (c *Cipher) Seal(plaintext, additionalData []byte) (string, error) {
+	return c.SealWithMetadata(plaintext, additionalData, nil)
+}
+
+// SealWithMetadata is like Seal, but also stores metadata in the envelope
+// header, where it is authenticated but not encrypted. Anyone can read it
+// with EnvelopeMetadata. Keys must be between 1 and 255 bytes, keys and
+// values must be valid UTF-8, and the encoded map is limited to 8 KiB and
+// counts towards the envelope size limit.
+func (c *Cipher) SealWithMetadata(plaintext, additionalData []byte, metadata map[string]string) (string, error) {
 	if err := c.validate(); err != nil {
 		return "", err
 	}
-	return sealWithKey(c.key[:], modeKey, nil, plaintext, additionalData, c.cfg)
+	cfg, err := c.cfg.withMetadata(metadata)
+	if err != nil {
+		return "", err
+	}
+	return c.seal(plaintext, additionalData, cfg, cfg.embedKeyID)
+}
+
+func (c *Cipher) seal(plaintext, additionalData []byte, cfg config, embedKeyID bool) (string, error) {
+	if cfg.dataKeys {
+		return sealDataKey(c, plaintext, additionalData, cfg)
+	}
+	if embedKeyID {
+		return sealWithKey(c.key[:], modeKeyID, keyIDHeader(c.keyID), plaintext, additionalData, cfg)
+	}
+	return sealWithKey(c.key[:], modeKey, nil, plaintext, additionalData, cfg)
 }
 
 // Open authenticates and decrypts a key-based SEC2 envelope.
 func (c *Cipher) Open(envelope string, additionalData []byte) ([]byte, error) {
+	plaintext, _, err := c.OpenWithMetadata(envelope, additionalData)
+	return plaintext, err
+}
+
+// OpenWithMetadata is like Open, but also returns the authenticated metadata
+// stored by SealWithMetadata, or nil if there is none.
+func (c *Cipher) OpenWithMetadata(envelope string, additionalData []byte) ([]byte, map[string]string, error) {
 	if err := c.validate(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(additionalData) > c.cfg.maxEnvelope {
-		return nil, ErrLimitExceeded
+		return nil, nil, ErrLimitExceeded
 	}
-	header, nonce, ciphertext, err := parseEnvelope(envelope, c.cfg.maxEnvelope)
+	env, err := parseEnvelope(envelope, c.cfg.maxEnvelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := c.open(env, additionalData)
+	if plaintext, err = c.cfg.checkExpiry(env, plaintext, err); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, envelopeMetadata(env), nil
+}
+
+func (c *Cipher) open(env envelope, additionalData []byte) ([]byte, error) {
+	if env.mode == modeMulti {
+		// Stanzas are wrapped with the recipient's own key.
+		return openMulti(c, env, additionalData)
+	}
+	c, err := c.derived(env.ext.derivation)
 	if err != nil {
 		return nil, err
 	}
-	if header[1] != modeKey {
+	switch env.mode {
+	case modeKey:
+	case modeKeyID:
+		if !bytes.Equal(env.fields[1:], c.keyID) {
+			return nil, ErrKeyMismatch
+		}
+	case modeDataKey:
+		return openDataKey(c, env, additionalData)
+	default:
 		return nil, fmt.Errorf("%w: envelope requires a password", ErrInvalidEnvelope)
 	}
-	return openAEAD(c.key[:], header, nonce, ciphertext, additionalData)
+	return openAEAD(c.key[:], env, additionalData)
 }
 
 // EncryptString encrypts a UTF-8 string without additional data.
@@ -213,43 +304,97 @@ func (c *Cipher) DecryptString(envelope string) (string, error) {
 
 // Seal encrypts plaintext using a fresh salt and Argon2id-derived key.
 func (p *PasswordCipher) Seal(plaintext, additionalData []byte) (string, error) {
+	return p.SealWithMetadata(plaintext, additionalData, nil)
+}
+
+// SealWithMetadata is like Seal, but also stores metadata in the envelope
+// header, where it is authenticated but not encrypted. The limits are those
+// of Cipher.SealWithMetadata.
+func (p *PasswordCipher) SealWithMetadata(plaintext, additionalData []byte, metadata map[string]string) (string, error) {
 	if err := p.validate(); err != nil {
 		return "", err
 	}
-	if err := checkSealSize(2+4+4+1+saltSize, len(plaintext), len(additionalData), p.cfg.maxEnvelope); err != nil {
+	cfg, err := p.cfg.withMetadata(metadata)
+	if err != nil {
+		return "", err
+	}
+	return p.seal(plaintext, additionalData, cfg)
+}
+
+func (p *PasswordCipher) seal(plaintext, additionalData []byte, cfg config) (string, error) {
+	// The header length does not depend on the key, and the key is not
+	// derived until the size is known to fit.
+	overhead := len(envelopePrefix(modePassword, cfg.envelopeExtensions(nil))) + passwordParamsSize + cfg.alg.nonceSize() + tagSize
+	if err := checkSealSize(overhead, len(plaintext), len(additionalData), cfg.maxEnvelope); err != nil {
 		return "", err
 	}
 	salt := make([]byte, saltSize)
 	if _, err := io.ReadFull(p.cfg.rand, salt); err != nil {
 		return "", fmt.Errorf("secure: generate salt: %w", err)
 	}
-	header := passwordHeader(p.cfg.argon, salt)
 	key := argon2.IDKey(p.passphrase, salt, p.cfg.argon.Time, p.cfg.argon.Memory, p.cfg.argon.Threads, keySize)
-	return sealWithKey(key, modePassword, header[2:], plaintext, additionalData, p.cfg.config)
+	defer clear(key)
+	return sealWithKey(key, modePassword, passwordParams(p.cfg.argon, salt), plaintext, additionalData, cfg)
 }
 
 // Open authenticates and decrypts a password-based SEC2 envelope.
 func (p *PasswordCipher) Open(envelope string, additionalData []byte) ([]byte, error) {
+	plaintext, _, err := p.OpenWithMetadata(envelope, additionalData)
+	return plaintext, err
+}
+
+// OpenWithMetadata is like Open, but also returns the authenticated metadata
+// stored by SealWithMetadata, or nil if there is none.
+func (p *PasswordCipher) OpenWithMetadata(envelope string, additionalData []byte) ([]byte, map[string]string, error) {
 	if err := p.validate(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(additionalData) > p.cfg.maxEnvelope {
-		return nil, ErrLimitExceeded
+		return nil, nil, ErrLimitExceeded
 	}
-	header, nonce, ciphertext, err := parseEnvelope(envelope, p.cfg.maxEnvelope)
+	env, err := parseEnvelope(envelope, p.cfg.maxEnvelope)
 	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := p.open(env, additionalData)
+	if plaintext, err = p.cfg.checkExpiry(env, plaintext, err); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, envelopeMetadata(env), nil
+}
+
+func (p *PasswordCipher) open(env envelope, additionalData []byte) ([]byte, error) {
+	if err := p.checkCommitted(env); err != nil {
 		return nil, err
 	}
-	if header[1] != modePassword || len(header) != 2+4+4+1+saltSize {
+	if env.mode == modeMulti {
+		return openMulti(p, env, additionalData)
+	}
+	if !isPasswordEnvelope(env) {
 		return nil, fmt.Errorf("%w: envelope does not contain password parameters", ErrInvalidEnvelope)
 	}
-	params := Argon2Parameters{binary.BigEndian.Uint32(header[2:6]), binary.BigEndian.Uint32(header[6:10]), header[10]}
+	params, salt := parsePasswordParams(env.fields)
 	if err := validateArgon2(params); err != nil {
 		return nil, err
 	}
-	salt := header[11 : 11+saltSize]
-	key := argon2.IDKey(p.passphrase, salt, params.Time, params.Memory, params.Threads, keySize)
-	return openAEAD(key, header, nonce, ciphertext, additionalData)
+	key := p.deriveKey(params, salt)
+	defer clear(key)
+	if env.mode == modePasswordSession {
+		subkey, err := sessionSubkey(key, env.fields[passwordParamsSize:])
+		if err != nil {
+			return nil, err
+		}
+		defer clear(subkey)
+		key = subkey
+	}
+	return openAEAD(key, env, additionalData)
+}
+
+// isPasswordEnvelope reports whether env was sealed with a key derived from
+// a password, directly or through a PasswordSession.
+func isPasswordEnvelope(env envelope) bool {
+	return env.mode == modePassword && len(env.fields) == passwordParamsSize ||
+		env.mode == modePasswordSession && len(env.fields) == sessionFieldsSize
 }
 
 func (p *PasswordCipher) EncryptString(plaintext string) (string, error) {
@@ -261,34 +406,45 @@ func (p *PasswordCipher) DecryptString(envelope string) (string, error) {
 	return string(b), err
 }
 
-func passwordHeader(p Argon2Parameters, salt []byte) []byte {
-	h := make([]byte, 2+4+4+1+saltSize)
-	h[0], h[1] = envelopeVersion, modePassword
-	binary.BigEndian.PutUint32(h[2:6], p.Time)
-	binary.BigEndian.PutUint32(h[6:10], p.Memory)
-	h[10] = p.Threads
-	copy(h[11:], salt)
-	return h
+// passwordParams encodes Argon2id parameters and a salt.
+func passwordParams(p Argon2Parameters, salt []byte) []byte {
+	b := make([]byte, passwordParamsSize)
+	binary.BigEndian.PutUint32(b[0:4], p.Time)
+	binary.BigEndian.PutUint32(b[4:8], p.Memory)
+	b[8] = p.Threads
+	copy(b[9:], salt)
+	return b
 }
 
-func sealWithKey(key []byte, mode byte, extraHeader, plaintext, additionalData []byte, cfg config) (string, error) {
-	header := append([]byte{envelopeVersion, mode}, extraHeader...)
-	if err := checkSealSize(len(header), len(plaintext), len(additionalData), cfg.maxEnvelope); err != nil {
-		return "", err
-	}
-	block, err := aes.NewCipher(key)
+func parsePasswordParams(b []byte) (Argon2Parameters, []byte) {
+	return Argon2Parameters{binary.BigEndian.Uint32(b[0:4]), binary.BigEndian.Uint32(b[4:8]), b[8]}, b[9:passwordParamsSize]
+}
+
+func sealWithKey(key []byte, mode byte, fields, plaintext, additionalData []byte, cfg config) (string, error) {
+	header := append(envelopePrefix(mode, cfg.envelopeExtensions(key)), fields...)
+	return sealEnvelope(key, header, len(header), plaintext, additionalData, cfg)
+}
+
+// sealEnvelope encrypts plaintext under key and packs it behind header. Only
+// the first authLen bytes of header are authenticated by the payload AEAD.
+func sealEnvelope(key, header []byte, authLen int, plaintext, additionalData []byte, cfg config) (string, error) {
+	aead, err := newAEAD(cfg.alg, key)
 	if err != nil {
 		return "", err
 	}
-	aead, err := cipher.NewGCM(block)
-	if err != nil {
+	return sealAEAD(aead, header, authLen, plaintext, additionalData, cfg)
+}
+
+func sealAEAD(aead cipher.AEAD, header []byte, authLen int, plaintext, additionalData []byte, cfg config) (string, error) {
+	overhead := len(header) + aead.NonceSize() + aead.Overhead()
+	if err := checkSealSize(overhead, len(plaintext), len(additionalData), cfg.maxEnvelope); err != nil {
 		return "", err
 	}
 	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(cfg.rand, nonce); err != nil {
 		return "", fmt.Errorf("secure: generate nonce: %w", err)
 	}
-	ciphertext := aead.Seal(nil, nonce, plaintext, envelopeAAD(header, additionalData))
+	ciphertext := aead.Seal(nil, nonce, plaintext, envelopeAAD(header[:authLen], additionalData))
 	packedLen := len(header) + len(nonce) + len(ciphertext)
 	if packedLen > cfg.maxEnvelope {
 		return "", ErrLimitExceeded
@@ -300,58 +456,122 @@ func sealWithKey(key []byte, mode byte, extraHeader, plaintext, additionalData [
 	return prefix + base64.RawURLEncoding.EncodeToString(packed), nil
 }
 
-func checkSealSize(headerLen, plaintextLen, additionalDataLen, limit int) error {
-	const gcmOverhead = 16
-	const nonceLen = 12
-	if additionalDataLen > limit || headerLen > limit-nonceLen-gcmOverhead || plaintextLen > limit-headerLen-nonceLen-gcmOverhead {
+// checkSealSize reports whether a plaintext fits in limit once overhead bytes
+// of header, nonce, and tag are added.
+func checkSealSize(overhead, plaintextLen, additionalDataLen, limit int) error {
+	if additionalDataLen > limit || overhead > limit || plaintextLen > limit-overhead {
 		return ErrLimitExceeded
 	}
 	return nil
 }
 
-func parseEnvelope(envelope string, limit int) (header, nonce, ciphertext []byte, err error) {
-	if len(envelope) < len(prefix) || envelope[:len(prefix)] != prefix {
-		return nil, nil, nil, ErrInvalidEnvelope
+// envelope is a parsed SEC2 envelope. fields holds the mode-specific header
+// fields that follow the version, mode, and extensions; auth is the part of
+// header that the payload AEAD authenticates.
+type envelope struct {
+	header     []byte
+	auth       []byte
+	fields     []byte
+	mode       byte
+	ext        extensions
+	nonce      []byte
+	ciphertext []byte
+}
+
+func parseEnvelope(s string, limit int) (envelope, error) {
+	var env envelope
+	if len(s) < len(prefix) || s[:len(prefix)] != prefix {
+		return env, ErrInvalidEnvelope
 	}
-	encoded := envelope[len(prefix):]
+	encoded := s[len(prefix):]
 	if base64.RawURLEncoding.DecodedLen(len(encoded)) > limit {
-		return nil, nil, nil, ErrLimitExceeded
+		return env, ErrLimitExceeded
 	}
 	packed, err := base64.RawURLEncoding.DecodeString(encoded)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("%w: invalid base64", ErrInvalidEnvelope)
+		return env, fmt.Errorf("%w: invalid base64", ErrInvalidEnvelope)
 	}
 	if len(packed) < 2 || packed[0] != envelopeVersion {
 		if len(packed) > 0 && packed[0] != envelopeVersion {
-			return nil, nil, nil, ErrUnsupportedVersion
+			return env, ErrUnsupportedVersion
 		}
-		return nil, nil, nil, ErrInvalidEnvelope
+		return env, ErrInvalidEnvelope
+	}
+	env.mode, env.ext = packed[1]&^flagExtensions, defaultExtensions()
+	prefixLen := 2
+	if packed[1]&flagExtensions != 0 {
+		x, n, ok := parseExtensionBlock(packed[2:])
+		if !ok || x.recordSize != 0 || x.records != 0 {
+			return env, ErrInvalidEnvelope
+		}
+		env.ext = x
+		prefixLen += n
 	}
-	headerLen := 2
-	switch packed[1] {
+	rest := packed[prefixLen:]
+	fieldsLen := 0
+	switch env.mode {
 	case modeKey:
 	case modePassword:
-		headerLen += 4 + 4 + 1 + saltSize
+		fieldsLen = passwordParamsSize
+	case modeKeyID:
+		if len(rest) < 1 || rest[0] == 0 || rest[0] > maxKeyIDSize {
+			return env, ErrInvalidEnvelope
+		}
+		fieldsLen = 1 + int(rest[0])
+	case modeDataKey:
+		_, _, n, ok := parseDataKeyHeader(rest)
+		if !ok {
+			return env, ErrInvalidEnvelope
+		}
+		fieldsLen = n
+	case modeX25519:
+		fieldsLen = x25519KeySize
+	case modeHybrid:
+		fieldsLen = hybridCiphertextSize
+	case modeMulti:
+		_, n, ok := parseStanzas(rest)
+		if !ok {
+			return env, ErrInvalidEnvelope
+		}
+		fieldsLen = n
+	case modePasswordSession:
+		fieldsLen = sessionFieldsSize
+	case modeDeterministic:
+		if env.ext != defaultExtensions() {
+			return env, ErrInvalidEnvelope
+		}
 	default:
-		return nil, nil, nil, ErrInvalidEnvelope
-	}
-	const nonceLen = 12
-	if len(packed) < headerLen+nonceLen+16 {
-		return nil, nil, nil, ErrInvalidEnvelope
-	}
-	return packed[:headerLen], packed[headerLen : headerLen+nonceLen], packed[headerLen+nonceLen:], nil
+		return env, ErrInvalidEnvelope
+	}
+	headerLen, nonceLen := prefixLen+fieldsLen, env.ext.alg.nonceSize()
+	if env.mode == modeDeterministic {
+		// The synthetic IV at the start of the ciphertext replaces the nonce.
+		nonceLen = 0
+	}
+	if len(packed) < headerLen+nonceLen+tagSize {
+		return env, ErrInvalidEnvelope
+	}
+	env.header = packed[:headerLen]
+	env.fields = packed[prefixLen:headerLen]
+	env.auth = env.header
+	if env.mode == modeDataKey {
+		// The wrapped data key may be replaced without re-encrypting.
+		env.auth = packed[:prefixLen]
+	}
+	env.nonce = packed[headerLen : headerLen+nonceLen]
+	env.ciphertext = packed[headerLen+nonceLen:]
+	return env, nil
 }
 
-func openAEAD(key, header, nonce, ciphertext, additionalData []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
+func openAEAD(key []byte, env envelope, additionalData []byte) ([]byte, error) {
+	if err := checkCommitment(key, env.ext); err != nil {
 		return nil, err
 	}
-	aead, err := cipher.NewGCM(block)
+	aead, err := newAEAD(env.ext.alg, key)
 	if err != nil {
 		return nil, err
 	}
-	plaintext, err := aead.Open(nil, nonce, ciphertext, envelopeAAD(header, additionalData))
+	plaintext, err := aead.Open(nil, env.nonce, env.ciphertext, envelopeAAD(env.auth, additionalData))
 	if err != nil {
 		return nil, ErrAuthentication
 	}
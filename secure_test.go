@@ -164,7 +164,8 @@ func TestPasswordCipher(t *testing.T) {
 	}
 
 	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
-	packed[2] = 0xff // force an excessive time cost without running Argon2
+	params := len(envelopePrefix(modePassword, p.cfg.envelopeExtensions(nil)))
+	packed[params] = 0xff // force an excessive time cost without running Argon2
 	bad := prefix + base64.RawURLEncoding.EncodeToString(packed)
 	if _, err := p.DecryptString(bad); !errors.Is(err, ErrLimitExceeded) {
 		t.Fatalf("hostile KDF error = %v", err)
@@ -254,7 +255,17 @@ func FuzzOpen(f *testing.F) {
 	c, _ := NewCipher(testKey, WithMaxEnvelopeSize(1024))
 	f.Add("plain")
 	f.Add(prefix)
+	x, _ := NewCipher(testKey, WithAlgorithm(XChaCha20Poly1305))
+	seed, _ := x.EncryptString("seed")
+	f.Add(seed)
+	d, _ := NewDeterministicCipher(testKey, WithMaxEnvelopeSize(1024))
+	seed, _ = d.EncryptString("seed")
+	f.Add(seed)
+	f.Add("SEC.AIIPjL0a2HgLgOySAw9fAT6ovih9MfzkMv_pyWmmkA3eBxYbDlLQ")
+	f.Add(streamMagic + "\x01")
 	f.Fuzz(func(t *testing.T, input string) {
 		_, _ = c.Open(input, nil)
+		_, _ = d.Open(input, nil)
+		_, _ = Inspect(input)
 	})
 }
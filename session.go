@@ -0,0 +1,145 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+// sessionFieldsSize is the size of the header fields of a password session
+// envelope: the Argon2id parameters and session salt, then the value salt.
+const sessionFieldsSize = passwordParamsSize + saltSize
+
+// PasswordSession seals many values with a single Argon2id derivation. It
+// derives a session key from the password and a random session salt once,
+// and encrypts each value under an HKDF-SHA256 subkey of that key and a
+// random per-value salt.
+//
+// Values sealed in one session are only as independent as the session key:
+// whoever recovers it, from memory or by guessing the password once, opens
+// every one of them, and the shared session salt in their headers shows that
+// they belong together. Guessing the password costs a full Argon2id
+// derivation as before. Use a session for values written together, such as
+// the fields of one configuration file, and Close it when done.
+//
+// Any PasswordCipher with the same password opens session envelopes; with
+// WithPasswordKeyCache it derives the session key only once.
+type PasswordSession struct {
+	mu     sync.RWMutex
+	p      *PasswordCipher
+	salt   []byte
+	key    []byte
+	closed bool
+}
+
+// NewSession derives a session key with p's Argon2id parameters.
+func (p *PasswordCipher) NewSession() (*PasswordSession, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(p.cfg.rand, salt); err != nil {
+		return nil, fmt.Errorf("secure: generate salt: %w", err)
+	}
+	key := argon2.IDKey(p.passphrase, salt, p.cfg.argon.Time, p.cfg.argon.Memory, p.cfg.argon.Threads, keySize)
+	return &PasswordSession{p: p, salt: salt, key: key}, nil
+}
+
+// Close zeroes the session key. The session cannot be used afterwards.
+func (s *PasswordSession) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.key)
+	s.closed = true
+	return nil
+}
+
+// lock read-locks an open session; the caller must unlock it.
+func (s *PasswordSession) lock() error {
+	if s == nil {
+		return ErrUnconfigured
+	}
+	s.mu.RLock()
+	if s.closed || s.p.validate() != nil {
+		s.mu.RUnlock()
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// Seal encrypts plaintext under a fresh subkey of the session key.
+func (s *PasswordSession) Seal(plaintext, additionalData []byte) (string, error) {
+	if err := s.lock(); err != nil {
+		return "", err
+	}
+	defer s.mu.RUnlock()
+	valueSalt := make([]byte, saltSize)
+	if _, err := io.ReadFull(s.p.cfg.rand, valueSalt); err != nil {
+		return "", fmt.Errorf("secure: generate salt: %w", err)
+	}
+	key, err := sessionSubkey(s.key, valueSalt)
+	if err != nil {
+		return "", err
+	}
+	defer clear(key)
+	fields := append(passwordParams(s.p.cfg.argon, s.salt), valueSalt...)
+	return sealWithKey(key, modePasswordSession, fields, plaintext, additionalData, s.p.cfg.config)
+}
+
+// Open authenticates and decrypts any envelope the session's PasswordCipher
+// opens. Envelopes sealed by this session are opened without Argon2id.
+func (s *PasswordSession) Open(envelope string, additionalData []byte) ([]byte, error) {
+	if err := s.lock(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+	p := s.p
+	if len(additionalData) > p.cfg.maxEnvelope {
+		return nil, ErrLimitExceeded
+	}
+	env, err := parseEnvelope(envelope, p.cfg.maxEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := s.open(env, additionalData)
+	return p.cfg.checkExpiry(env, plaintext, err)
+}
+
+func (s *PasswordSession) open(env envelope, additionalData []byte) ([]byte, error) {
+	if env.mode != modePasswordSession || !bytes.Equal(env.fields[:passwordParamsSize], passwordParams(s.p.cfg.argon, s.salt)) {
+		return s.p.open(env, additionalData)
+	}
+	if err := s.p.checkCommitted(env); err != nil {
+		return nil, err
+	}
+	key, err := sessionSubkey(s.key, env.fields[passwordParamsSize:])
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	return openAEAD(key, env, additionalData)
+}
+
+func (s *PasswordSession) EncryptString(plaintext string) (string, error) {
+	return s.Seal([]byte(plaintext), nil)
+}
+
+func (s *PasswordSession) DecryptString(envelope string) (string, error) {
+	b, err := s.Open(envelope, nil)
+	return string(b), err
+}
+
+func sessionSubkey(sessionKey, valueSalt []byte) ([]byte, error) {
+	r := hkdf.New(sha256.New, sessionKey, valueSalt, []byte("github.com/rusq/secure/v2 password session"))
+	key := make([]byte, keySize)
+	_, err := io.ReadFull(r, key)
+	return key, err
+}
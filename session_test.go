@@ -0,0 +1,131 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordSession(t *testing.T) {
+	p, _ := NewPasswordCipher([]byte("password"))
+	s, err := p.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var envelopes []string
+	for _, value := range []string{"host", "user", "token"} {
+		envelope, err := s.Seal([]byte(value), []byte("config"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	if got, err := s.Open(envelopes[0], []byte("config")); err != nil || string(got) != "host" {
+		t.Fatalf("session Open() = %q, %v", got, err)
+	}
+	if _, err := s.Open(envelopes[0], nil); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+
+	// Any cipher with the password opens the session, deriving its key once.
+	cached, _ := NewPasswordCipher([]byte("password"), WithPasswordKeyCache(8))
+	for i, value := range []string{"host", "user", "token"} {
+		if got, err := cached.Open(envelopes[i], []byte("config")); err != nil || string(got) != value {
+			t.Fatalf("Open(session envelope %d) = %q, %v", i, got, err)
+		}
+	}
+	if cached.cache.order.Len() != 1 {
+		t.Fatalf("cache holds %d keys, want 1", cached.cache.order.Len())
+	}
+	wrong, _ := NewPasswordCipher([]byte("wrong"))
+	if _, err := wrong.Open(envelopes[0], []byte("config")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong password error = %v", err)
+	}
+
+	// The session salt is authenticated.
+	packed := mustDecode(t, envelopes[0])
+	packed[2+passwordParamsSize-1] ^= 1
+	if _, err := cached.Open(prefix+base64.RawURLEncoding.EncodeToString(packed), []byte("config")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("modified session salt error = %v", err)
+	}
+	if _, err := s.Open(prefix+base64.RawURLEncoding.EncodeToString(packed), []byte("config")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("session Open(modified salt) error = %v", err)
+	}
+
+	// Other password envelopes are opened through the cipher.
+	other, _ := p.EncryptString("other")
+	if got, err := s.DecryptString(other); err != nil || got != "other" {
+		t.Fatalf("DecryptString(password envelope) = %q, %v", got, err)
+	}
+	encrypted, _ := s.EncryptString("string")
+	if got, err := s.DecryptString(encrypted); err != nil || got != "string" {
+		t.Fatalf("DecryptString() = %q, %v", got, err)
+	}
+
+	info, err := Inspect(envelopes[0])
+	if err != nil || info.Mode != ModePasswordSession || info.SaltLen != 2*saltSize || info.Argon2 != defaultArgon2Parameters() ||
+		!info.Committed || info.Mode.String() != "password session" {
+		t.Fatalf("Inspect() = %+v, %v", info, err)
+	}
+	if p.NeedsRehash(envelopes[0]) {
+		t.Fatal("current session envelope needs rehash")
+	}
+
+	s.Close()
+	if _, err := s.Seal(nil, nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("Seal after Close error = %v", err)
+	}
+	if _, err := s.Open(envelopes[0], nil); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("Open after Close error = %v", err)
+	}
+	if !bytes.Equal(s.key, make([]byte, keySize)) {
+		t.Fatal("Close did not zero the session key")
+	}
+	var unconfigured *PasswordSession
+	if _, err := unconfigured.Seal(nil, nil); !errors.Is(err, ErrUnconfigured) || unconfigured.Close() != nil {
+		t.Fatalf("nil session error = %v", err)
+	}
+	var nilCipher *PasswordCipher
+	if _, err := nilCipher.NewSession(); !errors.Is(err, ErrUnconfigured) {
+		t.Fatalf("nil NewSession error = %v", err)
+	}
+}
+
+func TestPasswordSessionLimits(t *testing.T) {
+	clock := &testClock{time.Unix(1_600_000_000, 0)}
+	p, _ := NewPasswordCipher([]byte("password"), WithPasswordMaxEnvelopeSize(256), WithPasswordExpiry(time.Minute), WithPasswordClock(clock.now))
+	s, _ := p.NewSession()
+	defer s.Close()
+	if _, err := s.Seal(make([]byte, 256), nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("oversized Seal error = %v", err)
+	}
+	if _, err := s.Open("SEC2.AA", make([]byte, 257)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("oversized AAD error = %v", err)
+	}
+	if _, err := s.Open("plain", nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("Open(plain) error = %v", err)
+	}
+	envelope, err := s.EncryptString("short-lived")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(time.Hour)
+	if _, err := s.DecryptString(envelope); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expired session envelope error = %v", err)
+	}
+	if _, err := p.DecryptString(envelope); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expired envelope error = %v", err)
+	}
+	if _, err := p.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	failing, _ := NewPasswordCipher([]byte("password"))
+	failing.cfg.rand = strings.NewReader("")
+	if _, err := failing.NewSession(); err == nil {
+		t.Fatal("NewSession succeeded without randomness")
+	}
+}
@@ -0,0 +1,147 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+const sivSize = 16
+
+// aesSIV implements nonce-less AES-SIV as specified in RFC 5297. The
+// synthetic IV is a CMAC-based PRF of the additional data and plaintext, and it
+// is prepended to the ciphertext, so equal inputs produce equal outputs.
+type aesSIV struct {
+	mac *cmac
+	ctr cipher.Block
+}
+
+// newAESSIV returns AES-SIV with a 32- or 64-byte key. The first half keys
+// S2V and the second half keys CTR.
+func newAESSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 && len(key) != 64 {
+		return nil, aes.KeySizeError(len(key))
+	}
+	macBlock, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &aesSIV{mac: newCMAC(macBlock), ctr: ctr}, nil
+}
+
+func (s *aesSIV) NonceSize() int { return 0 }
+
+func (s *aesSIV) Overhead() int { return sivSize }
+
+func (s *aesSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != 0 {
+		panic("secure: AES-SIV does not take a nonce")
+	}
+	v := s.s2v(additionalData, plaintext)
+	ret, out := sliceForAppend(dst, sivSize+len(plaintext))
+	copy(out, v[:])
+	s.xorKeyStream(v, out[sivSize:], plaintext)
+	return ret
+}
+
+func (s *aesSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != 0 {
+		panic("secure: AES-SIV does not take a nonce")
+	}
+	if len(ciphertext) < sivSize {
+		return nil, errors.New("secure: message authentication failed")
+	}
+	var v [sivSize]byte
+	copy(v[:], ciphertext)
+	ret, out := sliceForAppend(dst, len(ciphertext)-sivSize)
+	s.xorKeyStream(v, out, ciphertext[sivSize:])
+	expected := s.s2v(additionalData, out)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		clear(out)
+		return nil, errors.New("secure: message authentication failed")
+	}
+	return ret, nil
+}
+
+// s2v is the S2V construction of RFC 5297 over a single additional data
+// string followed by the plaintext.
+func (s *aesSIV) s2v(additionalData, plaintext []byte) [sivSize]byte {
+	var zero [sivSize]byte
+	d := s.mac.sum(zero[:])
+	d = dbl(d)
+	ad := s.mac.sum(additionalData)
+	subtle.XORBytes(d[:], d[:], ad[:])
+	if len(plaintext) >= sivSize {
+		t := make([]byte, len(plaintext))
+		defer clear(t)
+		copy(t, plaintext)
+		tail := t[len(t)-sivSize:]
+		subtle.XORBytes(tail, tail, d[:])
+		return s.mac.sum(t)
+	}
+	d = dbl(d)
+	var t [sivSize]byte
+	copy(t[:], plaintext)
+	t[len(plaintext)] = 0x80
+	subtle.XORBytes(t[:], t[:], d[:])
+	return s.mac.sum(t[:])
+}
+
+// xorKeyStream applies AES-CTR starting from v with bits 31 and 63 cleared,
+// which lets implementations use 64-bit counter arithmetic.
+func (s *aesSIV) xorKeyStream(v [sivSize]byte, dst, src []byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+// cmac computes AES-CMAC as specified in RFC 4493.
+type cmac struct {
+	block  cipher.Block
+	k1, k2 [sivSize]byte
+}
+
+func newCMAC(block cipher.Block) *cmac {
+	var l [sivSize]byte
+	block.Encrypt(l[:], l[:])
+	m := &cmac{block: block, k1: dbl(l)}
+	m.k2 = dbl(m.k1)
+	return m
+}
+
+func (m *cmac) sum(msg []byte) [sivSize]byte {
+	var x [sivSize]byte
+	for len(msg) > sivSize {
+		subtle.XORBytes(x[:], x[:], msg[:sivSize])
+		m.block.Encrypt(x[:], x[:])
+		msg = msg[sivSize:]
+	}
+	var last [sivSize]byte
+	copy(last[:], msg)
+	if len(msg) == sivSize {
+		subtle.XORBytes(last[:], last[:], m.k1[:])
+	} else {
+		last[len(msg)] = 0x80
+		subtle.XORBytes(last[:], last[:], m.k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	m.block.Encrypt(x[:], x[:])
+	return x
+}
+
+// dbl multiplies b by x in GF(2¹²⁸) with the big-endian convention of
+// RFC 5297, in constant time.
+func dbl(b [sivSize]byte) [sivSize]byte {
+	var out [sivSize]byte
+	carry := b[0] >> 7
+	for i := range sivSize - 1 {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[sivSize-1] = b[sivSize-1]<<1 ^ 0x87&-carry
+	return out
+}
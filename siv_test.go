@@ -0,0 +1,89 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCMACKnownAnswers(t *testing.T) {
+	// RFC 4493, section 4.
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	block, _ := aes.NewCipher(key)
+	m := newCMAC(block)
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		if got := m.sum(msg[:tc.n]); hex.EncodeToString(got[:]) != tc.want {
+			t.Fatalf("CMAC(%d bytes) = %x, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestAESSIVKnownAnswer(t *testing.T) {
+	// RFC 5297, Appendix A.1.
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	aad, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext, _ := hex.DecodeString("112233445566778899aabbccddee")
+	aead, err := newAESSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := aead.Seal(nil, nil, plaintext, aad)
+	if got := hex.EncodeToString(sealed); got != "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c" {
+		t.Fatalf("Seal() = %s", got)
+	}
+	opened, err := aead.Open(nil, nil, sealed, aad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Open() = %x, %v", opened, err)
+	}
+}
+
+func TestAESSIVRoundTrip(t *testing.T) {
+	aead, _ := newAESSIV(bytes.Repeat(testKey, 2))
+	aad := []byte("header")
+	for _, n := range []int{0, 1, 15, 16, 17, 1000} {
+		plaintext := bytes.Repeat([]byte{byte(n)}, n)
+		sealed := aead.Seal([]byte("dst"), nil, plaintext, aad)
+		if string(sealed[:3]) != "dst" || len(sealed) != 3+n+aead.Overhead() {
+			t.Fatalf("Seal() did not append to dst")
+		}
+		if again := aead.Seal(nil, nil, plaintext, aad); !bytes.Equal(again, sealed[3:]) {
+			t.Fatalf("Seal(%d bytes) is not deterministic", n)
+		}
+		opened, err := aead.Open(nil, nil, sealed[3:], aad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Open(%d bytes) = %v", n, err)
+		}
+		for _, i := range []int{3, len(sealed) - 1} {
+			tampered := append([]byte(nil), sealed[3:]...)
+			tampered[i-3] ^= 1
+			if _, err := aead.Open(nil, nil, tampered, aad); err == nil {
+				t.Fatalf("tampering at %d was accepted", i)
+			}
+		}
+		if _, err := aead.Open(nil, nil, sealed[3:], nil); err == nil {
+			t.Fatal("wrong AAD was accepted")
+		}
+	}
+	if _, err := aead.Open(nil, nil, make([]byte, aead.Overhead()-1), nil); err == nil {
+		t.Fatal("short ciphertext was accepted")
+	}
+	if _, err := newAESSIV(make([]byte, 48)); err == nil {
+		t.Fatal("accepted 48-byte key")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("nonce did not panic")
+		}
+	}()
+	aead.Seal(nil, make([]byte, 12), nil, nil)
+}
@@ -1,12 +1,15 @@
 package secure
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"slices"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/hkdf"
@@ -20,46 +23,70 @@ const (
 
 // NewEncryptWriter returns an authenticated streaming writer. Close must be
 // called to write the authenticated final record.
-func (c *Cipher) NewEncryptWriter(w io.Writer) (io.WriteCloser, error) {
+func (c *Cipher) NewEncryptWriter(w io.Writer, opts ...StreamOption) (io.WriteCloser, error) {
 	if err := c.validate(); err != nil {
 		return nil, err
 	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 	if w == nil {
 		return nil, errors.New("secure: nil writer")
 	}
+	if c.cfg.dataKeys {
+		return newDataKeyWriter(c, w, c.cfg, sc)
+	}
 	salt := make([]byte, saltSize)
 	if _, err := io.ReadFull(c.cfg.rand, salt); err != nil {
 		return nil, err
 	}
-	header := append([]byte(streamMagic), modeKey)
-	header = append(header, salt...)
+	x := c.cfg.streamExtensions(sc)
+	header := append(streamPrefix(modeKey, x), salt...)
 	key, err := deriveStreamKey(c.key[:], salt)
 	if err != nil {
 		return nil, err
 	}
-	return newEncryptWriter(w, key, header)
+	return newEncryptWriter(w, key, header, header, x, sc)
 }
 
 // NewDecryptReader reads and authenticates a key-based stream.
-func (c *Cipher) NewDecryptReader(r io.Reader) (io.Reader, error) {
+func (c *Cipher) NewDecryptReader(r io.Reader, opts ...StreamOption) (io.Reader, error) {
 	if err := c.validate(); err != nil {
 		return nil, err
 	}
-	header, salt, _, err := readStreamHeader(r, modeKey)
+	sc, err := applyStreamOptions(opts)
 	if err != nil {
 		return nil, err
 	}
-	key, err := deriveStreamKey(c.key[:], salt)
+	h, err := readStreamHeader(r, modeKey, modeDataKey, modeMulti)
 	if err != nil {
 		return nil, err
 	}
-	return newDecryptReader(r, key, header)
+	if h.mode == modeMulti {
+		return newMultiReader(c, r, h, sc)
+	}
+	if c, err = c.derived(h.ext.derivation); err != nil {
+		return nil, err
+	}
+	if h.mode == modeDataKey {
+		return newDataKeyReader(c, r, h, sc)
+	}
+	key, err := deriveStreamKey(c.key[:], h.salt)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, key, h, sc)
 }
 
-func (p *PasswordCipher) NewEncryptWriter(w io.Writer) (io.WriteCloser, error) {
+func (p *PasswordCipher) NewEncryptWriter(w io.Writer, opts ...StreamOption) (io.WriteCloser, error) {
 	if err := p.validate(); err != nil {
 		return nil, err
 	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 	if w == nil {
 		return nil, errors.New("secure: nil writer")
 	}
@@ -67,30 +94,32 @@ func (p *PasswordCipher) NewEncryptWriter(w io.Writer) (io.WriteCloser, error) {
 	if _, err := io.ReadFull(p.cfg.rand, salt); err != nil {
 		return nil, err
 	}
-	header := append([]byte(streamMagic), modePassword)
-	params := make([]byte, 9)
-	binary.BigEndian.PutUint32(params[:4], p.cfg.argon.Time)
-	binary.BigEndian.PutUint32(params[4:8], p.cfg.argon.Memory)
-	params[8] = p.cfg.argon.Threads
-	header = append(header, params...)
-	header = append(header, salt...)
+	x := p.cfg.streamExtensions(sc)
+	header := append(streamPrefix(modePassword, x), passwordParams(p.cfg.argon, salt)...)
 	key := argon2.IDKey(p.passphrase, salt, p.cfg.argon.Time, p.cfg.argon.Memory, p.cfg.argon.Threads, keySize)
-	return newEncryptWriter(w, key, header)
+	return newEncryptWriter(w, key, header, header, x, sc)
 }
 
-func (p *PasswordCipher) NewDecryptReader(r io.Reader) (io.Reader, error) {
+func (p *PasswordCipher) NewDecryptReader(r io.Reader, opts ...StreamOption) (io.Reader, error) {
 	if err := p.validate(); err != nil {
 		return nil, err
 	}
-	header, salt, params, err := readStreamHeader(r, modePassword)
+	sc, err := applyStreamOptions(opts)
 	if err != nil {
 		return nil, err
 	}
-	if err := validateArgon2(params); err != nil {
+	h, err := readStreamHeader(r, modePassword, modeMulti)
+	if err != nil {
+		return nil, err
+	}
+	if h.mode == modeMulti {
+		return newMultiReader(p, r, h, sc)
+	}
+	if err := validateArgon2(h.argon); err != nil {
 		return nil, err
 	}
-	key := argon2.IDKey(p.passphrase, salt, params.Time, params.Memory, params.Threads, keySize)
-	return newDecryptReader(r, key, header)
+	key := p.deriveKey(h.argon, h.salt)
+	return newDecryptReader(r, key, h, sc)
 }
 
 func deriveStreamKey(master, salt []byte) ([]byte, error) {
@@ -100,41 +129,214 @@ func deriveStreamKey(master, salt []byte) ([]byte, error) {
 	return key, err
 }
 
-func readStreamHeader(r io.Reader, wantMode byte) ([]byte, []byte, Argon2Parameters, error) {
+// streamHeader is a parsed SECS2 header. auth holds the header bytes that
+// every record authenticates; it omits fields that may be rewritten without
+// re-encrypting the stream, such as a wrapped data key.
+type streamHeader struct {
+	mode       byte
+	ext        extensions
+	auth       []byte
+	salt       []byte
+	argon      Argon2Parameters
+	keyID      []byte
+	wrappedKey []byte
+	ephemeral  []byte // ephemeral public key or KEM ciphertext
+	stanzas    []stanza
+}
+
+func readStreamHeader(r io.Reader, modes ...byte) (streamHeader, error) {
+	var h streamHeader
 	if r == nil {
-		return nil, nil, Argon2Parameters{}, errors.New("secure: nil reader")
+		return h, errors.New("secure: nil reader")
 	}
 	base := make([]byte, len(streamMagic)+1)
 	if _, err := io.ReadFull(r, base); err != nil {
-		return nil, nil, Argon2Parameters{}, ErrTruncated
+		return h, ErrTruncated
 	}
-	if string(base[:len(streamMagic)]) != streamMagic || base[len(streamMagic)] != wantMode {
-		return nil, nil, Argon2Parameters{}, ErrInvalidEnvelope
+	h.mode, h.ext = base[len(streamMagic)]&^flagExtensions, defaultExtensions()
+	if string(base[:len(streamMagic)]) != streamMagic || !slices.Contains(modes, h.mode) {
+		return h, ErrInvalidEnvelope
 	}
-	header := append([]byte(nil), base...)
-	var params Argon2Parameters
-	if wantMode == modePassword {
-		encoded := make([]byte, 9)
+	h.auth = append([]byte(nil), base...)
+	if base[len(streamMagic)]&flagExtensions != 0 {
+		x, raw, err := readExtensionBlock(r)
+		if err != nil {
+			return h, err
+		}
+		if x.committed || x.metadata != "" {
+			// Streams are never written with a key commitment or metadata.
+			return h, ErrInvalidEnvelope
+		}
+		h.ext = x
+		h.auth = append(h.auth, raw...)
+	}
+	switch h.mode {
+	case modePassword:
+		encoded := make([]byte, passwordParamsSize-saltSize)
 		if _, err := io.ReadFull(r, encoded); err != nil {
-			return nil, nil, params, ErrTruncated
+			return h, ErrTruncated
+		}
+		h.auth = append(h.auth, encoded...)
+		h.argon = Argon2Parameters{binary.BigEndian.Uint32(encoded[:4]), binary.BigEndian.Uint32(encoded[4:8]), encoded[8]}
+	case modeDataKey:
+		var err error
+		if h.keyID, h.wrappedKey, err = readDataKeyHeader(r); err != nil {
+			return h, err
+		}
+	case modeX25519, modeHybrid:
+		size := x25519KeySize
+		if h.mode == modeHybrid {
+			size = hybridCiphertextSize
+		}
+		h.ephemeral = make([]byte, size)
+		if _, err := io.ReadFull(r, h.ephemeral); err != nil {
+			return h, ErrTruncated
+		}
+		h.auth = append(h.auth, h.ephemeral...)
+	case modeMulti:
+		stanzas, raw, err := readStanzas(r)
+		if err != nil {
+			return h, err
 		}
-		header = append(header, encoded...)
-		params = Argon2Parameters{binary.BigEndian.Uint32(encoded[:4]), binary.BigEndian.Uint32(encoded[4:8]), encoded[8]}
+		h.stanzas = stanzas
+		h.auth = append(h.auth, raw...)
 	}
-	salt := make([]byte, saltSize)
-	if _, err := io.ReadFull(r, salt); err != nil {
-		return nil, nil, params, ErrTruncated
+	h.salt = make([]byte, saltSize)
+	if _, err := io.ReadFull(r, h.salt); err != nil {
+		return h, ErrTruncated
 	}
-	header = append(header, salt...)
-	return header, salt, params, nil
+	h.auth = append(h.auth, h.salt...)
+	return h, nil
 }
 
-func streamAEAD(key []byte) (cipher.AEAD, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+// StreamOption configures a single stream writer or reader.
+type StreamOption func(*streamConfig) error
+
+type streamConfig struct {
+	workers        int
+	recordSize     int
+	maxRecordSize  int
+	additionalData []byte // SHA-256 digest
+	metadata       []byte // encoded
+	trailer        bool
+}
+
+// maxStreamWorkers bounds WithStreamWorkers, and with it the records held in
+// memory by one stream.
+const maxStreamWorkers = 256
+
+// WithStreamWorkers seals or opens up to n records at once on separate
+// goroutines. Records keep their order and counter-based nonces, so the
+// output is the same as without the option. A stream then holds up to n
+// records in memory. The default is 1, which processes every record
+// on the calling goroutine.
+func WithStreamWorkers(n int) StreamOption {
+	return func(c *streamConfig) error {
+		if n < 1 || n > maxStreamWorkers {
+			return fmt.Errorf("%w: stream workers must be between 1 and %d", ErrLimitExceeded, maxStreamWorkers)
+		}
+		c.workers = n
+		return nil
+	}
+}
+
+// Record sizes accepted by WithStreamRecordSize and WithMaxStreamRecordSize.
+const (
+	minStreamRecordSize = 4 << 10
+	maxStreamRecordSize = 4 << 20
+)
+
+// WithStreamRecordSize sets the plaintext bytes per record written by a
+// stream writer to n, between 4 KiB and 4 MiB. Small records reach the reader
+// sooner, which suits pipes such as log shipping; large records lower the
+// per-record overhead of bulk data. A size other than the default 64 KiB is
+// recorded in the authenticated stream header. Readers ignore the option.
+func WithStreamRecordSize(n int) StreamOption {
+	return func(c *streamConfig) error {
+		if n < minStreamRecordSize || n > maxStreamRecordSize {
+			return fmt.Errorf("%w: stream record size must be between %d and %d bytes", ErrLimitExceeded, minStreamRecordSize, maxStreamRecordSize)
+		}
+		c.recordSize = n
+		return nil
+	}
+}
+
+// WithMaxStreamRecordSize makes a stream reader reject streams whose records
+// hold more than n plaintext bytes, bounding the memory a stream from an
+// untrusted source may claim. n must be between 4 KiB and 4 MiB, and the
+// default accepts every size a writer may choose. Writers ignore the option.
+func WithMaxStreamRecordSize(n int) StreamOption {
+	return func(c *streamConfig) error {
+		if n < minStreamRecordSize || n > maxStreamRecordSize {
+			return fmt.Errorf("%w: maximum stream record size must be between %d and %d bytes", ErrLimitExceeded, minStreamRecordSize, maxStreamRecordSize)
+		}
+		c.maxRecordSize = n
+		return nil
+	}
+}
+
+// WithStreamAdditionalData authenticates additionalData, such as an object
+// key or tenant ID, with every record of a stream without storing it, like
+// the additional data of Seal. A reader must be given the same data, or the
+// first record fails with ErrAuthentication, so a stream written for one
+// object cannot be passed off as another. Empty data is the same as none.
+func WithStreamAdditionalData(additionalData []byte) StreamOption {
+	return func(c *streamConfig) error {
+		c.additionalData = nil
+		if len(additionalData) > 0 {
+			digest := sha256.Sum256(additionalData)
+			c.additionalData = digest[:]
+		}
+		return nil
+	}
+}
+
+func applyStreamOptions(opts []StreamOption) (streamConfig, error) {
+	cfg := streamConfig{workers: 1, recordSize: streamChunkSize, maxRecordSize: maxStreamRecordSize}
+	for _, opt := range opts {
+		if opt == nil {
+			return streamConfig{}, fmt.Errorf("%w: nil stream option", ErrInvalidEnvelope)
+		}
+		if err := opt(&cfg); err != nil {
+			return streamConfig{}, err
+		}
+	}
+	if len(cfg.metadata) > cfg.recordSize {
+		return streamConfig{}, fmt.Errorf("%w: stream metadata exceeds the %d byte record size", ErrLimitExceeded, cfg.recordSize)
+	}
+	return cfg, nil
+}
+
+// recordAuth returns the bytes every record authenticates before its counter
+// and record header: the authenticated header, followed by the digest of the
+// additional data if there is any. The digest keeps the cost per record
+// constant however long the data is.
+func (c streamConfig) recordAuth(authHeader []byte) []byte {
+	return append(slices.Clip(authHeader), c.additionalData...)
+}
+
+// streamRecord is one record of a stream: the plaintext and flags, and the
+// encoded record header followed by the ciphertext.
+type streamRecord struct {
+	counter   uint64
+	flags     byte
+	plaintext []byte
+	encoded   []byte
+	err       error
+}
+
+// parallel calls f for every record, on separate goroutines if there is more
+// than one.
+func parallel(records []streamRecord, f func(*streamRecord)) {
+	if len(records) == 1 {
+		f(&records[0])
+		return
+	}
+	var wg sync.WaitGroup
+	for i := range records {
+		wg.Go(func() { f(&records[i]) })
 	}
-	return cipher.NewGCM(block)
+	wg.Wait()
 }
 
 type encryptWriter struct {
@@ -142,20 +344,38 @@ type encryptWriter struct {
 	aead    cipher.AEAD
 	header  []byte
 	buffer  []byte
+	chunk   int
 	counter uint64
+	workers int
+	pending []streamRecord
+	hash    hash.Hash // of the plaintext, if there is a trailer
+	size    uint64
 	closed  bool
 	err     error
 }
 
-func newEncryptWriter(w io.Writer, key, header []byte) (*encryptWriter, error) {
-	aead, err := streamAEAD(key)
+func newEncryptWriter(w io.Writer, key, header, authHeader []byte, x extensions, sc streamConfig) (*encryptWriter, error) {
+	aead, err := newAEAD(x.alg, key)
 	if err != nil {
 		return nil, err
 	}
 	if err := writeAll(w, header); err != nil {
 		return nil, err
 	}
-	return &encryptWriter{w: w, aead: aead, header: header, buffer: make([]byte, 0, streamChunkSize)}, nil
+	chunk := x.chunkSize()
+	ew := &encryptWriter{w: w, aead: aead, header: sc.recordAuth(authHeader), buffer: make([]byte, 0, chunk), chunk: chunk, workers: sc.workers}
+	if sc.metadata != nil {
+		record := streamRecord{flags: streamMetadata, plaintext: sc.metadata}
+		ew.sealRecord(&record)
+		if err := writeAll(w, record.encoded); err != nil {
+			return nil, err
+		}
+		ew.counter++
+	}
+	if sc.trailer {
+		ew.hash = sha256.New()
+	}
+	return ew, nil
 }
 
 func (w *encryptWriter) Write(p []byte) (int, error) {
@@ -167,16 +387,19 @@ func (w *encryptWriter) Write(p []byte) (int, error) {
 	}
 	written := 0
 	for len(p) > 0 {
-		n := min(len(p), streamChunkSize-len(w.buffer))
+		n := min(len(p), w.chunk-len(w.buffer))
 		w.buffer = append(w.buffer, p[:n]...)
+		if w.hash != nil {
+			w.hash.Write(p[:n])
+			w.size += uint64(n)
+		}
 		p = p[n:]
 		written += n
-		if len(w.buffer) == streamChunkSize {
+		if len(w.buffer) == w.chunk {
 			if err := w.writeRecord(w.buffer, 0); err != nil {
 				w.err = err
 				return written, err
 			}
-			w.buffer = w.buffer[:0]
 		}
 	}
 	return written, nil
@@ -196,53 +419,114 @@ func (w *encryptWriter) Close() error {
 			return err
 		}
 	}
-	w.err = w.writeRecord(nil, streamFinal)
+	if w.hash != nil {
+		if err := w.writeRecord(encodeStreamTrailer(w.size, w.hash.Sum(nil)), streamTrailer); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	if w.err = w.writeRecord(nil, streamFinal); w.err == nil {
+		w.err = w.flush()
+	}
 	return w.err
 }
 
+// writeRecord queues plaintext as the next record, which takes ownership of
+// the buffer, and writes the queue once every worker has a record.
 func (w *encryptWriter) writeRecord(plaintext []byte, flags byte) error {
 	if w.counter == ^uint64(0) {
 		return ErrLimitExceeded
 	}
-	recordHeader := make([]byte, 5)
-	binary.BigEndian.PutUint32(recordHeader[:4], uint32(len(plaintext)))
-	recordHeader[4] = flags
-	nonce := streamNonce(w.counter)
-	ciphertext := w.aead.Seal(nil, nonce, plaintext, streamAAD(w.header, w.counter, recordHeader))
-	if err := writeAll(w.w, recordHeader); err != nil {
-		return err
+	w.pending = append(w.pending, streamRecord{counter: w.counter, flags: flags, plaintext: plaintext})
+	w.counter++
+	if len(w.pending) < w.workers {
+		w.buffer = make([]byte, 0, w.chunk)
+		return nil
 	}
-	if err := writeAll(w.w, ciphertext); err != nil {
-		return err
+	w.buffer = plaintext[:0]
+	return w.flush()
+}
+
+func (w *encryptWriter) flush() error {
+	parallel(w.pending, w.sealRecord)
+	for _, r := range w.pending {
+		if err := writeAll(w.w, r.encoded); err != nil {
+			return err
+		}
 	}
-	w.counter++
+	w.pending = w.pending[:0]
 	return nil
 }
 
+func (w *encryptWriter) sealRecord(r *streamRecord) {
+	recordHeader := make([]byte, 5, 5+len(r.plaintext)+w.aead.Overhead())
+	binary.BigEndian.PutUint32(recordHeader[:4], uint32(len(r.plaintext)))
+	recordHeader[4] = r.flags
+	nonce := streamNonce(w.aead.NonceSize(), r.counter)
+	r.encoded = w.aead.Seal(recordHeader, nonce, r.plaintext, streamAAD(w.header, r.counter, recordHeader))
+}
+
 type decryptReader struct {
 	r       io.Reader
 	aead    cipher.AEAD
 	header  []byte
 	buffer  []byte
+	queue   [][]byte // opened records after buffer
+	chunk   int
 	counter uint64
+	workers int
+	records byte // flags of the optional records
+	hash    hash.Hash
+	size    uint64
 	done    bool
 	err     error
+
+	metadata *StreamMetadata
+	trailer  *StreamTrailer
 }
 
-func newDecryptReader(r io.Reader, key, header []byte) (*decryptReader, error) {
-	aead, err := streamAEAD(key)
+func newDecryptReader(r io.Reader, key []byte, h streamHeader, sc streamConfig) (*decryptReader, error) {
+	if h.ext.chunkSize() > sc.maxRecordSize {
+		return nil, fmt.Errorf("%w: stream records of %d bytes exceed the maximum of %d", ErrLimitExceeded, h.ext.chunkSize(), sc.maxRecordSize)
+	}
+	aead, err := newAEAD(h.ext.alg, key)
 	if err != nil {
 		return nil, err
 	}
-	return &decryptReader{r: r, aead: aead, header: header}, nil
+	dr := &decryptReader{r: r, aead: aead, header: sc.recordAuth(h.auth), chunk: h.ext.chunkSize(), workers: sc.workers, records: h.ext.records}
+	if dr.records&streamTrailer != 0 {
+		dr.hash = sha256.New()
+	}
+	if dr.records&streamMetadata != 0 {
+		record, err := dr.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if record.flags != streamMetadata {
+			return nil, ErrInvalidEnvelope
+		}
+		if dr.openRecord(&record); record.err != nil {
+			return nil, record.err
+		}
+		m, ok := decodeStreamMetadata(record.plaintext)
+		if !ok {
+			return nil, ErrInvalidEnvelope
+		}
+		dr.metadata = &m
+	}
+	return dr, nil
 }
 
 func (r *decryptReader) Read(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
-	for len(r.buffer) == 0 && !r.done && r.err == nil {
-		r.readRecord()
+	for len(r.buffer) == 0 && (len(r.queue) > 0 || !r.done && r.err == nil) {
+		if len(r.queue) == 0 {
+			r.readRecords()
+			continue
+		}
+		r.buffer, r.queue = r.queue[0], r.queue[1:]
 	}
 	if len(r.buffer) > 0 {
 		n := copy(p, r.buffer)
@@ -255,39 +539,106 @@ func (r *decryptReader) Read(p []byte) (int, error) {
 	return 0, io.EOF
 }
 
-func (r *decryptReader) readRecord() {
+// readRecords reads up to one record per worker and opens them. Records
+// before the first error are returned before the error.
+func (r *decryptReader) readRecords() {
+	var records []streamRecord
+	var readErr error
+	for len(records) < r.workers {
+		record, err := r.readRecord()
+		if err != nil {
+			readErr = err
+			break
+		}
+		records = append(records, record)
+		if record.flags == streamFinal {
+			break
+		}
+	}
+	if len(records) > 0 {
+		parallel(records, r.openRecord)
+	}
+	for _, record := range records {
+		if record.err != nil {
+			r.err = record.err
+			return
+		}
+		switch {
+		case record.flags == streamFinal:
+			if r.hash != nil && r.trailer == nil {
+				r.err = ErrInvalidEnvelope
+				return
+			}
+			r.done = true
+			return
+		case record.flags == streamTrailer:
+			t, ok := decodeStreamTrailer(record.plaintext)
+			if !ok || r.trailer != nil || uint64(t.Size) != r.size || [sha256.Size]byte(r.hash.Sum(nil)) != t.SHA256 {
+				r.err = ErrInvalidEnvelope
+				return
+			}
+			r.trailer = &t
+		case r.trailer != nil:
+			// Only the final record may follow the trailer.
+			r.err = ErrInvalidEnvelope
+			return
+		case len(record.plaintext) > 0:
+			if r.hash != nil {
+				r.hash.Write(record.plaintext)
+				r.size += uint64(len(record.plaintext))
+			}
+			r.queue = append(r.queue, record.plaintext)
+		}
+	}
+	r.err = readErr
+}
+
+func (r *decryptReader) readRecord() (streamRecord, error) {
 	recordHeader := make([]byte, 5)
 	if _, err := io.ReadFull(r.r, recordHeader); err != nil {
-		r.err = ErrTruncated
-		return
+		return streamRecord{}, ErrTruncated
 	}
 	length := binary.BigEndian.Uint32(recordHeader[:4])
 	flags := recordHeader[4]
-	if length > streamChunkSize || flags&^streamFinal != 0 || flags == streamFinal && length != 0 {
-		r.err = ErrInvalidEnvelope
-		return
-	}
-	ciphertext := make([]byte, int(length)+r.aead.Overhead())
-	if _, err := io.ReadFull(r.r, ciphertext); err != nil {
-		r.err = ErrTruncated
-		return
-	}
-	plaintext, err := r.aead.Open(nil, streamNonce(r.counter), ciphertext, streamAAD(r.header, r.counter, recordHeader))
-	if err != nil {
-		r.err = ErrAuthentication
-		return
-	}
+	valid := false
+	switch flags {
+	case 0:
+		valid = length <= uint32(r.chunk)
+	case streamMetadata:
+		valid = r.counter == 0 && r.records&streamMetadata != 0 && length <= uint32(r.chunk)
+	case streamTrailer:
+		valid = r.records&streamTrailer != 0 && length == streamTrailerSize
+	case streamFinal:
+		valid = length == 0
+	}
+	if !valid {
+		return streamRecord{}, ErrInvalidEnvelope
+	}
+	encoded := make([]byte, 5+int(length)+r.aead.Overhead())
+	copy(encoded, recordHeader)
+	if _, err := io.ReadFull(r.r, encoded[5:]); err != nil {
+		return streamRecord{}, ErrTruncated
+	}
+	record := streamRecord{counter: r.counter, flags: flags, encoded: encoded}
 	r.counter++
-	if flags == streamFinal {
-		r.done = true
+	return record, nil
+}
+
+func (r *decryptReader) openRecord(record *streamRecord) {
+	recordHeader, ciphertext := record.encoded[:5], record.encoded[5:]
+	plaintext, err := r.aead.Open(ciphertext[:0], streamNonce(r.aead.NonceSize(), record.counter), ciphertext, streamAAD(r.header, record.counter, recordHeader))
+	if err != nil {
+		record.err = ErrAuthentication
 		return
 	}
-	r.buffer = plaintext
+	record.plaintext = plaintext
 }
 
-func streamNonce(counter uint64) []byte {
-	nonce := make([]byte, 12)
-	binary.BigEndian.PutUint64(nonce[4:], counter)
+// streamNonce places the record counter in the last eight bytes of the nonce.
+// Every stream has its own key, so counters never repeat under one key.
+func streamNonce(size int, counter uint64) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-8:], counter)
 	return nonce
 }
 
@@ -2,6 +2,8 @@ package secure
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -250,3 +252,263 @@ func TestPasswordStreamRejectsHostileParameters(t *testing.T) {
 		t.Fatalf("hostile parameters error = %v", err)
 	}
 }
+
+func TestStreamWorkers(t *testing.T) {
+	input := make([]byte, 5*streamChunkSize+3)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+	seal := func(c *Cipher, chunks int, opts ...StreamOption) []byte {
+		c.cfg.rand = bytes.NewReader(make([]byte, saltSize)) // a fixed salt
+		var buf bytes.Buffer
+		w, err := c.NewEncryptWriter(&buf, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p := input; len(p) > 0; p = p[min(chunks, len(p)):] {
+			if _, err := w.Write(p[:min(chunks, len(p))]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for _, alg := range []Algorithm{AES256GCM, XChaCha20Poly1305, AES256GCMSIV} {
+		c, _ := NewCipher(testKey, WithAlgorithm(alg))
+		want := seal(c, len(input))
+		for _, workers := range []int{2, 3, 8} {
+			if got := seal(c, 10000, WithStreamWorkers(workers)); !bytes.Equal(got, want) {
+				t.Fatalf("%v: %d workers changed the stream", alg, workers)
+			}
+			r, err := c.NewDecryptReader(bytes.NewReader(want), WithStreamWorkers(workers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+				t.Fatalf("%v: %d workers read %d bytes, %v", alg, workers, len(got), err)
+			}
+		}
+	}
+
+	// Records before a modified or missing record are still returned.
+	c, _ := NewCipher(testKey)
+	stream := seal(c, len(input))
+	recordSize := 5 + streamChunkSize + tagSize
+	headerSize := len(stream) - 5*recordSize - (5 + 3 + tagSize) - (5 + tagSize)
+	modified := bytes.Clone(stream)
+	modified[headerSize+3*recordSize+10] ^= 1
+	for name, tt := range map[string]struct {
+		stream []byte
+		want   error
+	}{
+		"modified":  {modified, ErrAuthentication},
+		"truncated": {stream[:headerSize+3*recordSize+10], ErrTruncated},
+	} {
+		r, _ := c.NewDecryptReader(bytes.NewReader(tt.stream), WithStreamWorkers(8))
+		got, err := io.ReadAll(r)
+		if !errors.Is(err, tt.want) || !bytes.Equal(got, input[:3*streamChunkSize]) {
+			t.Fatalf("%s: read %d bytes, %v", name, len(got), err)
+		}
+	}
+
+	for _, opt := range []StreamOption{WithStreamWorkers(0), WithStreamWorkers(maxStreamWorkers + 1), nil} {
+		if _, err := c.NewEncryptWriter(io.Discard, opt); err == nil {
+			t.Fatal("NewEncryptWriter accepted an invalid option")
+		}
+		if _, err := c.NewDecryptReader(bytes.NewReader(stream), opt); err == nil {
+			t.Fatal("NewDecryptReader accepted an invalid option")
+		}
+	}
+}
+
+func TestParallelStreamPropagatesWriteFailure(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	w, err := c.NewEncryptWriter(&shortWriter{remaining: 100}, WithStreamWorkers(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first records are only queued.
+	if _, err := w.Write(make([]byte, 3*streamChunkSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, streamChunkSize)); err == nil {
+		t.Fatal("Write succeeded after the destination failed")
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("Close succeeded after the destination failed")
+	}
+}
+
+func TestStreamRecordSize(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	input := make([]byte, 3*minStreamRecordSize+5)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+	seal := func(opts ...StreamOption) []byte {
+		var buf bytes.Buffer
+		w, err := c.NewEncryptWriter(&buf, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for _, size := range []int{minStreamRecordSize, streamChunkSize, maxStreamRecordSize} {
+		stream := seal(WithStreamRecordSize(size))
+		info, err := Inspect(string(stream))
+		if err != nil || info.RecordSize != size {
+			t.Fatalf("Inspect reported %d byte records, %v", info.RecordSize, err)
+		}
+		r, err := c.NewDecryptReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+			t.Fatalf("%d byte records: read %d bytes, %v", size, len(got), err)
+		}
+		ra, err := c.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 10)
+		if _, err := ra.ReadAt(got, minStreamRecordSize-5); err != nil || !bytes.Equal(got, input[minStreamRecordSize-5:minStreamRecordSize+5]) {
+			t.Fatalf("%d byte records: ReadAt returned %v", size, err)
+		}
+	}
+
+	// The default size is not recorded, so such streams stay unchanged.
+	if got, want := len(seal(WithStreamRecordSize(streamChunkSize))), len(seal()); got != want {
+		t.Fatalf("explicit default size wrote %d bytes, want %d", got, want)
+	}
+	small := seal(WithStreamRecordSize(minStreamRecordSize))
+	recordSize := 5 + minStreamRecordSize + tagSize
+	if want := len(streamMagic) + 1 + 2 + 7 + saltSize + 3*recordSize + (5 + 5 + tagSize) + (5 + tagSize); len(small) != want {
+		t.Fatalf("stream is %d bytes, want %d", len(small), want)
+	}
+
+	if _, err := c.NewDecryptReader(bytes.NewReader(small), WithMaxStreamRecordSize(minStreamRecordSize)); err != nil {
+		t.Fatal(err)
+	}
+	large := seal(WithStreamRecordSize(2 * streamChunkSize))
+	if _, err := c.NewDecryptReader(bytes.NewReader(large), WithMaxStreamRecordSize(streamChunkSize)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("reader accepted records above its maximum: %v", err)
+	}
+	if _, err := c.NewDecryptReaderAt(bytes.NewReader(large), int64(len(large)), WithMaxStreamRecordSize(streamChunkSize)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("random-access reader accepted records above its maximum: %v", err)
+	}
+
+	// The size is authenticated, and records may not exceed it.
+	headerSize := len(streamMagic) + 1 + 2 + 7 + saltSize
+	changed := bytes.Clone(small)
+	changed[headerSize-saltSize-2]++
+	oversized := bytes.Clone(small)
+	binary.BigEndian.PutUint32(oversized[headerSize:], minStreamRecordSize+1)
+	for name, tt := range map[string]struct {
+		stream []byte
+		want   error
+	}{
+		"changed size":     {changed, ErrAuthentication},
+		"oversized record": {oversized, ErrInvalidEnvelope},
+	} {
+		r, err := c.NewDecryptReader(bytes.NewReader(tt.stream))
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%s: got %v, want %v", name, err, tt.want)
+		}
+	}
+
+	// Only sizes a writer may choose are accepted, and envelopes have none.
+	for _, size := range []uint32{0, minStreamRecordSize - 1, streamChunkSize, maxStreamRecordSize + 1} {
+		block := appendExtension(nil, extRecordSize, binary.BigEndian.AppendUint32(nil, size))
+		header := append([]byte(streamMagic), modeKey|flagExtensions, 0, byte(len(block)))
+		header = append(append(header, block...), make([]byte, saltSize)...)
+		if _, err := c.NewDecryptReader(bytes.NewReader(header)); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("record size %d: got %v", size, err)
+		}
+	}
+	packed := envelopePrefix(modeKey, extensions{alg: AES256GCM, recordSize: minStreamRecordSize})
+	packed = append(packed, make([]byte, 12+tagSize)...)
+	if _, err := c.Open(prefix+base64.RawURLEncoding.EncodeToString(packed), nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("envelope with a record size: got %v", err)
+	}
+
+	for _, opt := range []StreamOption{
+		WithStreamRecordSize(minStreamRecordSize - 1), WithStreamRecordSize(maxStreamRecordSize + 1),
+		WithMaxStreamRecordSize(minStreamRecordSize - 1), WithMaxStreamRecordSize(maxStreamRecordSize + 1),
+	} {
+		if _, err := c.NewEncryptWriter(io.Discard, opt); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("NewEncryptWriter accepted an invalid option: %v", err)
+		}
+	}
+}
+
+func TestStreamAdditionalData(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	input := bytes.Repeat([]byte("object"), streamChunkSize/3)
+	read := func(stream []byte, opts ...StreamOption) ([]byte, error) {
+		r, err := c.NewDecryptReader(bytes.NewReader(stream), opts...)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	a := encryptStream(t, c, input, WithStreamAdditionalData([]byte("tenant-a/report.pdf")))
+	if got, err := read(a, WithStreamAdditionalData([]byte("tenant-a/report.pdf"))); err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("read %d bytes, %v", len(got), err)
+	}
+	ra, err := c.NewDecryptReaderAt(bytes.NewReader(a), int64(len(a)), WithStreamAdditionalData([]byte("tenant-a/report.pdf")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 10)
+	if _, err := ra.ReadAt(got, streamChunkSize-5); err != nil || !bytes.Equal(got, input[streamChunkSize-5:streamChunkSize+5]) {
+		t.Fatalf("ReadAt returned %v", err)
+	}
+
+	// The data is not stored.
+	plain := encryptStream(t, c, input)
+	if len(a) != len(plain) {
+		t.Fatalf("stream with additional data is %d bytes, want %d", len(a), len(plain))
+	}
+	if got, err := read(encryptStream(t, c, input, WithStreamAdditionalData(nil))); err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("empty additional data: read %d bytes, %v", len(got), err)
+	}
+
+	// A stream swapped in for another object, or read without its data, fails.
+	for name, tt := range map[string]struct {
+		stream []byte
+		opts   []StreamOption
+	}{
+		"other object": {a, []StreamOption{WithStreamAdditionalData([]byte("tenant-b/report.pdf"))}},
+		"missing":      {a, nil},
+		"unexpected":   {plain, []StreamOption{WithStreamAdditionalData([]byte("tenant-a/report.pdf"))}},
+	} {
+		if got, err := read(tt.stream, tt.opts...); !errors.Is(err, ErrAuthentication) || len(got) != 0 {
+			t.Fatalf("%s: read %d bytes, %v", name, len(got), err)
+		}
+	}
+	if _, err := c.NewDecryptReaderAt(bytes.NewReader(a), int64(len(a))); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("random-access reader without additional data: %v", err)
+	}
+
+	// Public-key streams take the data in the same way.
+	id, _ := GenerateX25519Identity()
+	sealed := encryptStream(t, id.Recipient(), input, WithStreamAdditionalData([]byte("id")))
+	r, err := id.NewDecryptReader(bytes.NewReader(sealed), WithStreamAdditionalData([]byte("id")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("X25519 stream: read %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,194 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"time"
+	"unicode/utf8"
+)
+
+// Records with these flags are present only if the extRecords extension of
+// the stream header lists them. The metadata record is the first record, and
+// the trailer comes just before the final record.
+const (
+	streamMetadata = byte(2)
+	streamTrailer  = byte(4)
+)
+
+// streamTrailerSize is the plaintext size of a trailer record: the plaintext
+// length followed by its SHA-256 digest.
+const streamTrailerSize = 8 + sha256.Size
+
+// StreamMetadata describes the plaintext of a stream. WithStreamMetadata
+// stores it encrypted in the stream, so it needs no plaintext sidecar.
+type StreamMetadata struct {
+	Name        string
+	ContentType string
+	// ModTime is stored with nanosecond precision and read back in the local
+	// time zone; the zero time is not stored.
+	ModTime time.Time
+	Fields  map[string]string
+}
+
+// StreamTrailer records the length and SHA-256 digest of the plaintext of a
+// stream written with WithStreamTrailer.
+type StreamTrailer struct {
+	Size   int64
+	SHA256 [sha256.Size]byte
+}
+
+// WithStreamMetadata makes a stream writer store m in an encrypted,
+// authenticated record after the header. The encoded metadata must fit in
+// one record, 64 KiB unless WithStreamRecordSize says otherwise, and names,
+// content types, and fields must be valid UTF-8. Readers ignore the option;
+// StreamMetadataOf returns the metadata of a stream being read.
+func WithStreamMetadata(m StreamMetadata) StreamOption {
+	return func(c *streamConfig) error {
+		encoded, err := encodeStreamMetadata(m)
+		if err != nil {
+			return err
+		}
+		c.metadata = encoded
+		return nil
+	}
+}
+
+// WithStreamTrailer makes Close write an encrypted trailer record holding the
+// length and SHA-256 digest of the plaintext. Readers ignore the option;
+// StreamTrailerOf returns the trailer of a stream read to the end.
+func WithStreamTrailer() StreamOption {
+	return func(c *streamConfig) error {
+		c.trailer = true
+		return nil
+	}
+}
+
+// StreamMetadataOf returns the metadata written by WithStreamMetadata, for a
+// reader returned by NewDecryptReader or NewDecryptReaderAt. The metadata is
+// authenticated before the reader is returned. ok is false if the stream has
+// none or r is another reader.
+func StreamMetadataOf(r io.Reader) (m StreamMetadata, ok bool) {
+	var p *StreamMetadata
+	switch r := r.(type) {
+	case *decryptReader:
+		p = r.metadata
+	case *DecryptReaderAt:
+		p = r.metadata
+	}
+	if p == nil {
+		return StreamMetadata{}, false
+	}
+	m = *p
+	m.Fields = maps.Clone(m.Fields)
+	return m, true
+}
+
+// StreamTrailerOf returns the trailer written by WithStreamTrailer. A reader
+// returned by NewDecryptReader has it once Read returns io.EOF, and has then
+// checked it against the plaintext it returned. A DecryptReaderAt reads the
+// trailer when it is created and checks only the length, since it does not
+// read the whole plaintext. ok is false until then, if the stream has no
+// trailer, or if r is another reader.
+func StreamTrailerOf(r io.Reader) (t StreamTrailer, ok bool) {
+	var p *StreamTrailer
+	switch r := r.(type) {
+	case *decryptReader:
+		p = r.trailer
+	case *DecryptReaderAt:
+		p = r.trailer
+	}
+	if p == nil {
+		return StreamTrailer{}, false
+	}
+	return *p, true
+}
+
+// encodeStreamMetadata encodes m as nameLen(2) name typeLen(2) type, then a
+// byte that is 1 if seconds(8) and nanoseconds(4) of the modification time
+// follow, then the fields as encoded by encodeMetadata.
+func encodeStreamMetadata(m StreamMetadata) ([]byte, error) {
+	if len(m.Name) > math.MaxUint16 || len(m.ContentType) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: stream metadata names and content types must be at most %d bytes", ErrLimitExceeded, math.MaxUint16)
+	}
+	if !utf8.ValidString(m.Name) || !utf8.ValidString(m.ContentType) {
+		return nil, errors.New("secure: stream metadata must be valid UTF-8")
+	}
+	fields, err := encodeMetadata(m.Fields)
+	if err != nil {
+		return nil, err
+	}
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(m.Name)))
+	b = append(b, m.Name...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.ContentType)))
+	b = append(b, m.ContentType...)
+	if m.ModTime.IsZero() {
+		b = append(b, 0)
+	} else {
+		b = append(b, 1)
+		b = binary.BigEndian.AppendUint64(b, uint64(m.ModTime.Unix()))
+		b = binary.BigEndian.AppendUint32(b, uint32(m.ModTime.Nanosecond()))
+	}
+	return append(b, fields...), nil
+}
+
+// decodeStreamMetadata decodes metadata, reporting false unless it is in the
+// form written by encodeStreamMetadata.
+func decodeStreamMetadata(b []byte) (StreamMetadata, bool) {
+	var m StreamMetadata
+	var ok bool
+	if m.Name, b, ok = cutString16(b); !ok {
+		return m, false
+	}
+	if m.ContentType, b, ok = cutString16(b); !ok {
+		return m, false
+	}
+	if !utf8.ValidString(m.Name) || !utf8.ValidString(m.ContentType) || len(b) < 1 || b[0] > 1 {
+		return m, false
+	}
+	if b, ok = b[1:], b[0] == 1; ok {
+		if len(b) < 12 {
+			return m, false
+		}
+		sec, nsec := int64(binary.BigEndian.Uint64(b)), binary.BigEndian.Uint32(b[8:])
+		if nsec >= 1e9 {
+			return m, false
+		}
+		if m.ModTime = time.Unix(sec, int64(nsec)); m.ModTime.IsZero() {
+			return m, false
+		}
+		b = b[12:]
+	}
+	if len(b) > 0 {
+		if m.Fields, ok = decodeMetadata(b); !ok {
+			return m, false
+		}
+	}
+	return m, true
+}
+
+func cutString16(b []byte) (s string, rest []byte, ok bool) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil, false
+	}
+	n := 2 + int(binary.BigEndian.Uint16(b))
+	return string(b[2:n]), b[n:], true
+}
+
+func encodeStreamTrailer(size uint64, digest []byte) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, size), digest...)
+}
+
+func decodeStreamTrailer(b []byte) (StreamTrailer, bool) {
+	var t StreamTrailer
+	if len(b) != streamTrailerSize || binary.BigEndian.Uint64(b) > math.MaxInt64 {
+		return t, false
+	}
+	t.Size = int64(binary.BigEndian.Uint64(b))
+	copy(t.SHA256[:], b[8:])
+	return t, true
+}
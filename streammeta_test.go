@@ -0,0 +1,217 @@
+package secure
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamMetadataAndTrailer(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	input := bytes.Repeat([]byte("upload"), streamChunkSize/2)
+	meta := StreamMetadata{
+		Name:        "report.pdf",
+		ContentType: "application/pdf",
+		ModTime:     time.Date(2026, 10, 16, 12, 30, 0, 123456789, time.UTC),
+		Fields:      map[string]string{"owner": "tenant-42"},
+	}
+	for _, workers := range []int{1, 4} {
+		stream := encryptStream(t, c, input, WithStreamMetadata(meta), WithStreamTrailer(), WithStreamWorkers(workers))
+		info, err := Inspect(string(stream))
+		if err != nil || !info.MetadataRecord || !info.TrailerRecord {
+			t.Fatalf("Inspect = %+v, %v", info, err)
+		}
+		if strings.Contains(string(stream), meta.Name) || strings.Contains(string(stream), meta.Fields["owner"]) {
+			t.Fatal("metadata stored in the clear")
+		}
+
+		r, err := c.NewDecryptReader(bytes.NewReader(stream), WithStreamWorkers(workers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := StreamMetadataOf(r)
+		if !ok || got.Name != meta.Name || got.ContentType != meta.ContentType || !got.ModTime.Equal(meta.ModTime) || got.Fields["owner"] != "tenant-42" {
+			t.Fatalf("StreamMetadataOf = %+v, %v", got, ok)
+		}
+		if _, ok := StreamTrailerOf(r); ok {
+			t.Fatal("trailer available before the end of the stream")
+		}
+		plaintext, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(plaintext, input) {
+			t.Fatalf("read %d bytes, %v", len(plaintext), err)
+		}
+		trailer, ok := StreamTrailerOf(r)
+		if !ok || trailer.Size != int64(len(input)) || trailer.SHA256 != sha256.Sum256(input) {
+			t.Fatalf("StreamTrailerOf = %+v, %v", trailer, ok)
+		}
+
+		ra, err := c.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ra.Size() != int64(len(input)) {
+			t.Fatalf("Size = %d, want %d", ra.Size(), len(input))
+		}
+		if got, ok := StreamMetadataOf(ra); !ok || got.Name != meta.Name {
+			t.Fatalf("StreamMetadataOf(DecryptReaderAt) = %+v, %v", got, ok)
+		}
+		if got, ok := StreamTrailerOf(ra); !ok || got != trailer {
+			t.Fatalf("StreamTrailerOf(DecryptReaderAt) = %+v, %v", got, ok)
+		}
+		buf := make([]byte, 10)
+		if _, err := ra.ReadAt(buf, streamChunkSize-5); err != nil || !bytes.Equal(buf, input[streamChunkSize-5:streamChunkSize+5]) {
+			t.Fatalf("ReadAt returned %v", err)
+		}
+	}
+
+	// Either record may be used alone, and an empty stream has a trailer.
+	for name, opts := range map[string][]StreamOption{
+		"metadata": {WithStreamMetadata(StreamMetadata{})},
+		"trailer":  {WithStreamTrailer()},
+	} {
+		for _, plaintext := range [][]byte{nil, input[:100]} {
+			stream := encryptStream(t, c, plaintext, opts...)
+			r, err := c.NewDecryptReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, plaintext) {
+				t.Fatalf("%s: read %d bytes, %v", name, len(got), err)
+			}
+			_, hasMeta := StreamMetadataOf(r)
+			trailer, hasTrailer := StreamTrailerOf(r)
+			if hasMeta != (name == "metadata") || hasTrailer != (name == "trailer") || hasTrailer && trailer.Size != int64(len(plaintext)) {
+				t.Fatalf("%s: metadata %v, trailer %+v %v", name, hasMeta, trailer, hasTrailer)
+			}
+			ra, err := c.NewDecryptReaderAt(bytes.NewReader(stream), int64(len(stream)))
+			if err != nil || ra.Size() != int64(len(plaintext)) {
+				t.Fatalf("%s: DecryptReaderAt %v", name, err)
+			}
+		}
+	}
+	if _, ok := StreamMetadataOf(bytes.NewReader(nil)); ok {
+		t.Fatal("StreamMetadataOf accepted another reader")
+	}
+	if _, ok := StreamTrailerOf(bytes.NewReader(nil)); ok {
+		t.Fatal("StreamTrailerOf accepted another reader")
+	}
+}
+
+func TestStreamMetadataRejectsModifiedStreams(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	input := bytes.Repeat([]byte{7}, streamChunkSize+10)
+	stream := encryptStream(t, c, input, WithStreamMetadata(StreamMetadata{Name: "a.txt"}), WithStreamTrailer())
+	plain := encryptStream(t, c, input)
+	plainHeaderSize := len(streamMagic) + 1 + saltSize
+	headerSize := len(streamMagic) + 1 + 2 + 4 + saltSize
+	trailerSize := 5 + streamTrailerSize + tagSize
+	finalSize := 5 + tagSize
+	modifiedMetadata := bytes.Clone(stream)
+	modifiedMetadata[headerSize+10] ^= 1
+	withoutMetadata := bytes.Clone(stream)
+	withoutMetadata[headerSize-saltSize-1] = streamTrailer
+	dataAsMetadata := encryptStream(t, c, input, WithStreamTrailer())
+	dataAsMetadata[headerSize-saltSize-1] |= streamMetadata
+	withoutTrailer := append(bytes.Clone(stream[:len(stream)-finalSize-trailerSize]), stream[len(stream)-finalSize:]...)
+	modifiedTrailer := bytes.Clone(stream)
+	modifiedTrailer[len(stream)-finalSize-10] ^= 1
+	for name, tt := range map[string]struct {
+		stream []byte
+		want   error
+	}{
+		"metadata modified": {modifiedMetadata, ErrAuthentication},
+		"metadata unlisted": {withoutMetadata, ErrInvalidEnvelope},
+		"data as metadata":  {dataAsMetadata, ErrInvalidEnvelope},
+		"trailer removed":   {withoutTrailer, ErrAuthentication},
+		"trailer modified":  {modifiedTrailer, ErrAuthentication},
+	} {
+		r, err := c.NewDecryptReader(bytes.NewReader(tt.stream))
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("%s: got %v, want %v", name, err, tt.want)
+		}
+		if _, err := c.NewDecryptReaderAt(bytes.NewReader(tt.stream), int64(len(tt.stream))); err == nil {
+			t.Fatalf("%s: DecryptReaderAt accepted a modified stream", name)
+		}
+	}
+
+	// An authenticated trailer must still match the plaintext.
+	var lying bytes.Buffer
+	w, _ := c.NewEncryptWriter(&lying, WithStreamTrailer())
+	w.Write(input)
+	w.(*encryptWriter).size++
+	w.Close()
+	r, _ := c.NewDecryptReader(bytes.NewReader(lying.Bytes()))
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("mismatched trailer: got %v", err)
+	}
+	if _, err := c.NewDecryptReaderAt(bytes.NewReader(lying.Bytes()), int64(lying.Len())); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("mismatched trailer in DecryptReaderAt: got %v", err)
+	}
+
+	// Optional records are only accepted where the header lists them.
+	for _, flags := range []byte{streamMetadata, streamTrailer} {
+		forged := bytes.Clone(plain)
+		forged[plainHeaderSize+4] = flags
+		r, err := c.NewDecryptReader(bytes.NewReader(forged))
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if !errors.Is(err, ErrInvalidEnvelope) {
+			t.Fatalf("record flags %d: got %v", flags, err)
+		}
+	}
+
+	if _, err := c.NewEncryptWriter(io.Discard, WithStreamMetadata(StreamMetadata{Name: "\xff"})); err == nil || errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("invalid name: got %v", err)
+	}
+	if _, err := c.NewEncryptWriter(io.Discard, WithStreamMetadata(StreamMetadata{Fields: map[string]string{"": "x"}})); err == nil || errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("empty field key: got %v", err)
+	}
+	long := StreamMetadata{Fields: map[string]string{"note": strings.Repeat("x", 5000)}}
+	if _, err := c.NewEncryptWriter(io.Discard, WithStreamMetadata(long), WithStreamRecordSize(minStreamRecordSize)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("metadata larger than a record: got %v", err)
+	}
+	if _, err := c.NewEncryptWriter(io.Discard, WithStreamMetadata(long)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeStreamMetadata(t *testing.T) {
+	m := StreamMetadata{Name: "a", ContentType: "text/plain", ModTime: time.Unix(1, 2), Fields: map[string]string{"k": "v"}}
+	encoded, err := encodeStreamMetadata(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := decodeStreamMetadata(encoded); !ok || !got.ModTime.Equal(m.ModTime) || got.Fields["k"] != "v" {
+		t.Fatalf("decodeStreamMetadata = %+v, %v", got, ok)
+	}
+	timeAt := 2 + 1 + 2 + len(m.ContentType)
+	for name, b := range map[string][]byte{
+		"empty":          nil,
+		"short name":     {0, 5, 'a'},
+		"invalid name":   {0, 1, 0xff, 0, 0, 0},
+		"short type":     {0, 0, 0, 1},
+		"no time flag":   {0, 0, 0, 0},
+		"bad time flag":  {0, 0, 0, 0, 2},
+		"short time":     {0, 0, 0, 0, 1, 0},
+		"nanoseconds":    append(bytes.Clone(encoded[:timeAt+9]), 0xff, 0xff, 0xff, 0xff),
+		"invalid fields": append(bytes.Clone(encoded[:timeAt+13]), 0),
+	} {
+		if _, ok := decodeStreamMetadata(b); ok {
+			t.Fatalf("%s: accepted", name)
+		}
+	}
+	if _, ok := decodeStreamTrailer(make([]byte, streamTrailerSize-1)); ok {
+		t.Fatal("short trailer accepted")
+	}
+	if _, ok := decodeStreamTrailer(bytes.Repeat([]byte{0xff}, streamTrailerSize)); ok {
+		t.Fatal("negative trailer size accepted")
+	}
+}
@@ -0,0 +1,284 @@
+package secure
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const x25519KeySize = 32
+
+// X25519Recipient seals envelopes and streams to an X25519 public key. Only
+// the holder of the matching X25519Identity can open them; holding the
+// recipient does not allow decryption.
+type X25519Recipient struct {
+	pub *ecdh.PublicKey
+	cfg config
+}
+
+// NewX25519Recipient creates a recipient from a 32-byte X25519 public key.
+func NewX25519Recipient(publicKey []byte, opts ...Option) (*X25519Recipient, error) {
+	pub, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("secure: invalid X25519 public key: %w", err)
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Recipient{pub: pub, cfg: cfg}, nil
+}
+
+func (r *X25519Recipient) validate() error {
+	if r == nil || r.pub == nil || r.cfg.maxEnvelope == 0 || r.cfg.rand == nil {
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// Bytes returns the recipient's public key.
+func (r *X25519Recipient) Bytes() []byte {
+	if r == nil || r.pub == nil {
+		return nil
+	}
+	return r.pub.Bytes()
+}
+
+// String encodes the recipient for ParseRecipient.
+func (r *X25519Recipient) String() string {
+	if r == nil || r.pub == nil {
+		return ""
+	}
+	return x25519RecipientPrefix + base64.RawURLEncoding.EncodeToString(r.pub.Bytes())
+}
+
+// Seal encrypts plaintext to the recipient using a fresh ephemeral key.
+func (r *X25519Recipient) Seal(plaintext, additionalData []byte) (string, error) {
+	if err := r.validate(); err != nil {
+		return "", err
+	}
+	ephemeral, key, err := r.encapsulate()
+	if err != nil {
+		return "", err
+	}
+	defer clear(key)
+	return sealWithKey(key, modeX25519, ephemeral, plaintext, additionalData, r.cfg)
+}
+
+// EncryptString encrypts a UTF-8 string without additional data.
+func (r *X25519Recipient) EncryptString(plaintext string) (string, error) {
+	return r.Seal([]byte(plaintext), nil)
+}
+
+// NewEncryptWriter returns an authenticated streaming writer sealed to the
+// recipient. Close must be called to write the authenticated final record.
+func (r *X25519Recipient) NewEncryptWriter(w io.Writer, opts ...StreamOption) (io.WriteCloser, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, errors.New("secure: nil writer")
+	}
+	ephemeral, shared, err := r.encapsulate()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(shared)
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r.cfg.rand, salt); err != nil {
+		return nil, err
+	}
+	x := r.cfg.streamExtensions(sc)
+	header := append(streamPrefix(modeX25519, x), ephemeral...)
+	header = append(header, salt...)
+	key, err := deriveStreamKey(shared, salt)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptWriter(w, key, header, header, x, sc)
+}
+
+// encapsulate generates an ephemeral key pair and returns its public half with
+// the key it shares with the recipient.
+func (r *X25519Recipient) encapsulate() (ephemeral, key []byte, err error) {
+	priv, err := ecdh.X25519().GenerateKey(r.cfg.rand)
+	if err != nil {
+		return nil, nil, fmt.Errorf("secure: generate ephemeral key: %w", err)
+	}
+	ephemeral = priv.PublicKey().Bytes()
+	key, err = x25519Key(priv, r.pub, ephemeral, r.pub.Bytes())
+	return ephemeral, key, err
+}
+
+// X25519Identity holds an X25519 private key and opens envelopes and streams
+// sealed to its public key. It also implements Codec by sealing to itself.
+type X25519Identity struct {
+	priv *ecdh.PrivateKey
+	cfg  config
+}
+
+// GenerateX25519Identity creates an identity with a new random private key.
+func GenerateX25519Identity(opts ...Option) (*X25519Identity, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdh.X25519().GenerateKey(cfg.rand)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{priv: priv, cfg: cfg}, nil
+}
+
+// NewX25519Identity creates an identity from a 32-byte X25519 private key.
+func NewX25519Identity(privateKey []byte, opts ...Option) (*X25519Identity, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("secure: invalid X25519 private key: %w", err)
+	}
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{priv: priv, cfg: cfg}, nil
+}
+
+func (i *X25519Identity) validate() error {
+	if i == nil || i.priv == nil || i.cfg.maxEnvelope == 0 || i.cfg.rand == nil {
+		return ErrUnconfigured
+	}
+	return nil
+}
+
+// Bytes returns the identity's private key. Handle it like any other secret.
+func (i *X25519Identity) Bytes() []byte {
+	if i == nil || i.priv == nil {
+		return nil
+	}
+	return i.priv.Bytes()
+}
+
+// Recipient returns the public recipient for this identity.
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	if i == nil || i.priv == nil {
+		return nil
+	}
+	return &X25519Recipient{pub: i.priv.PublicKey(), cfg: i.cfg}
+}
+
+// Seal encrypts plaintext to the identity's own public key.
+func (i *X25519Identity) Seal(plaintext, additionalData []byte) (string, error) {
+	if err := i.validate(); err != nil {
+		return "", err
+	}
+	return i.Recipient().Seal(plaintext, additionalData)
+}
+
+// Open authenticates and decrypts an envelope sealed to the identity.
+func (i *X25519Identity) Open(envelope string, additionalData []byte) ([]byte, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+	if len(additionalData) > i.cfg.maxEnvelope {
+		return nil, ErrLimitExceeded
+	}
+	env, err := parseEnvelope(envelope, i.cfg.maxEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := i.open(env, additionalData)
+	return i.cfg.checkExpiry(env, plaintext, err)
+}
+
+func (i *X25519Identity) open(env envelope, additionalData []byte) ([]byte, error) {
+	if env.mode == modeMulti {
+		return openMulti(i, env, additionalData)
+	}
+	if env.mode != modeX25519 {
+		return nil, fmt.Errorf("%w: envelope is not sealed to a public key", ErrInvalidEnvelope)
+	}
+	key, err := i.decapsulate(env.fields)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	return openAEAD(key, env, additionalData)
+}
+
+// EncryptString encrypts a UTF-8 string to the identity without additional data.
+func (i *X25519Identity) EncryptString(plaintext string) (string, error) {
+	return i.Seal([]byte(plaintext), nil)
+}
+
+// DecryptString decrypts a UTF-8 string without additional data.
+func (i *X25519Identity) DecryptString(envelope string) (string, error) {
+	b, err := i.Open(envelope, nil)
+	return string(b), err
+}
+
+// NewDecryptReader reads and authenticates a stream sealed to the identity.
+func (i *X25519Identity) NewDecryptReader(r io.Reader, opts ...StreamOption) (io.Reader, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+	sc, err := applyStreamOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readStreamHeader(r, modeX25519, modeMulti)
+	if err != nil {
+		return nil, err
+	}
+	if h.mode == modeMulti {
+		return newMultiReader(i, r, h, sc)
+	}
+	shared, err := i.decapsulate(h.ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(shared)
+	key, err := deriveStreamKey(shared, h.salt)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, key, h, sc)
+}
+
+func (i *X25519Identity) decapsulate(ephemeral []byte) ([]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, ErrInvalidEnvelope
+	}
+	key, err := x25519Key(i.priv, pub, ephemeral, i.priv.PublicKey().Bytes())
+	if err != nil {
+		// Low-order ephemeral keys produce an all-zero shared secret.
+		return nil, ErrAuthentication
+	}
+	return key, nil
+}
+
+// x25519Key derives a content key from an X25519 agreement. The ephemeral
+// and recipient public keys are bound into the derivation.
+func x25519Key(priv *ecdh.PrivateKey, pub *ecdh.PublicKey, ephemeral, recipient []byte) ([]byte, error) {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(shared)
+	salt := append(append([]byte(nil), ephemeral...), recipient...)
+	r := hkdf.New(sha256.New, shared, salt, []byte("github.com/rusq/secure/v2 x25519"))
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
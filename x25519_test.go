@@ -0,0 +1,170 @@
+package secure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestX25519Envelope(t *testing.T) {
+	backend, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingest, err := NewX25519Recipient(backend.Recipient().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := []byte("customer/9")
+	envelope, err := ingest.Seal([]byte("card number"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.Open(envelope, aad); err != nil || string(got) != "card number" {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	if _, err := backend.Open(envelope, []byte("customer/10")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong AAD error = %v", err)
+	}
+	other, _ := GenerateX25519Identity()
+	if _, err := other.Open(envelope, aad); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("other identity error = %v", err)
+	}
+
+	restored, err := NewX25519Identity(backend.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := restored.EncryptString("to self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.DecryptString(self); err != nil || got != "to self" {
+		t.Fatalf("DecryptString() = %q, %v", got, err)
+	}
+	second, _ := ingest.EncryptString("card number")
+	first, _ := ingest.EncryptString("card number")
+	if first == second {
+		t.Fatal("ephemeral keys were reused")
+	}
+}
+
+func TestX25519Stream(t *testing.T) {
+	backend, _ := GenerateX25519Identity()
+	input := bytes.Repeat([]byte("backup"), streamChunkSize/2)
+	var encrypted bytes.Buffer
+	w, err := backend.Recipient().NewEncryptWriter(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := backend.NewDecryptReader(bytes.NewReader(encrypted.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("ReadAll() = %d bytes, %v", len(got), err)
+	}
+
+	other, _ := GenerateX25519Identity()
+	r, err = other.NewDecryptReader(bytes.NewReader(encrypted.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("other identity error = %v", err)
+	}
+	tampered := append([]byte(nil), encrypted.Bytes()...)
+	tampered[len(streamMagic)+1] ^= 1
+	r, err = backend.NewDecryptReader(bytes.NewReader(tampered))
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if err == nil {
+		t.Fatal("tampered ephemeral key was accepted")
+	}
+	if _, err := backend.NewDecryptReader(bytes.NewReader(encrypted.Bytes()[:len(streamMagic)+8])); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("truncated header error = %v", err)
+	}
+	if _, err := backend.Recipient().NewEncryptWriter(nil); err == nil {
+		t.Fatal("accepted nil writer")
+	}
+}
+
+func TestX25519RejectsHostileInput(t *testing.T) {
+	backend, _ := GenerateX25519Identity()
+	envelope, _ := backend.EncryptString("x")
+	packed, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(envelope, prefix))
+
+	lowOrder := append([]byte(nil), packed...)
+	copy(lowOrder[2:2+x25519KeySize], make([]byte, x25519KeySize))
+	if _, err := backend.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(lowOrder)); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("low-order point error = %v", err)
+	}
+	for _, offset := range []int{2, 2 + x25519KeySize, len(packed) - 1} {
+		mutated := append([]byte(nil), packed...)
+		mutated[offset] ^= 1
+		if _, err := backend.DecryptString(prefix + base64.RawURLEncoding.EncodeToString(mutated)); err == nil {
+			t.Fatalf("tampering at %d was accepted", offset)
+		}
+	}
+
+	c, _ := NewCipher(testKey)
+	keyEnvelope, _ := c.EncryptString("key")
+	if _, err := backend.DecryptString(keyEnvelope); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("key envelope error = %v", err)
+	}
+	if _, err := c.DecryptString(envelope); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("cipher opened public-key envelope: %v", err)
+	}
+	for _, key := range [][]byte{nil, make([]byte, 31)} {
+		if _, err := NewX25519Recipient(key); err == nil {
+			t.Fatalf("accepted %d-byte public key", len(key))
+		}
+		if _, err := NewX25519Identity(key); err == nil {
+			t.Fatalf("accepted %d-byte private key", len(key))
+		}
+	}
+	if _, err := NewX25519Recipient(backend.Recipient().Bytes(), nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("nil option error = %v", err)
+	}
+	if _, err := NewX25519Identity(backend.Bytes(), nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("nil option error = %v", err)
+	}
+	if _, err := GenerateX25519Identity(nil); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Fatalf("nil option error = %v", err)
+	}
+	if _, err := backend.Open(prefix, make([]byte, defaultMaxEnvelope+1)); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("large AAD error = %v", err)
+	}
+}
+
+func TestX25519Unconfigured(t *testing.T) {
+	var r *X25519Recipient
+	var i *X25519Identity
+	if r.Bytes() != nil || i.Bytes() != nil || i.Recipient() != nil {
+		t.Fatal("nil keys returned data")
+	}
+	for name, call := range map[string]func() error{
+		"Recipient Seal":          func() error { _, err := r.Seal(nil, nil); return err },
+		"Recipient writer":        func() error { _, err := r.NewEncryptWriter(io.Discard); return err },
+		"Identity Seal":           func() error { _, err := i.Seal(nil, nil); return err },
+		"Identity Open":           func() error { _, err := i.Open("", nil); return err },
+		"Identity reader":         func() error { _, err := i.NewDecryptReader(bytes.NewReader(nil)); return err },
+		"zero Recipient Seal":     func() error { _, err := new(X25519Recipient).Seal(nil, nil); return err },
+		"zero Identity Open":      func() error { _, err := new(X25519Identity).Open("", nil); return err },
+		"zero Identity Recipient": func() error { _, err := new(X25519Identity).Seal(nil, nil); return err },
+	} {
+		if err := call(); !errors.Is(err, ErrUnconfigured) {
+			t.Fatalf("%s error = %v", name, err)
+		}
+	}
+}